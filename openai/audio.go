@@ -0,0 +1,138 @@
+// @file audio.go
+// @brief Audio transcription API implementation for OpenAI API. (https://platform.openai.com/docs/api-reference/audio)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"strconv"
+)
+
+// defaultTranscriptionModel is the model used by transcriptions when none is given.
+const defaultTranscriptionModel = "whisper-1"
+
+// TranscriptionSegment is a segment of a transcription, only returned with the "verbose_json" response format.
+type TranscriptionSegment struct {
+	// ID is the index of the segment.
+	ID int `json:"id"`
+	// Start is the start time of the segment in seconds.
+	Start float64 `json:"start"`
+	// End is the end time of the segment in seconds.
+	End float64 `json:"end"`
+	// Text is the text of the segment.
+	Text string `json:"text"`
+}
+
+// TranscriptionResponse is the transcription object is used to represent the text of an audio file.
+type TranscriptionResponse struct {
+	// Text is the transcribed text. With the "text", "srt" and "vtt" response formats, it is the whole response body.
+	Text string `json:"text"`
+	// Language is the language of the audio, only returned with the "verbose_json" response format.
+	Language string `json:"language"`
+	// Duration is the duration of the audio in seconds, only returned with the "verbose_json" response format.
+	Duration float64 `json:"duration"`
+	// Segments is the segments of the transcription, only returned with the "verbose_json" response format.
+	Segments []TranscriptionSegment `json:"segments"`
+}
+
+// TranscriptionOption configures a transcription request, see Chat.Transcribe.
+type TranscriptionOption func(fields map[string]string) error
+
+// WithTranscriptionLanguage language string Optional;
+// The language of the audio in ISO-639-1 format, such as "en". Supplying it improves accuracy and latency.
+func WithTranscriptionLanguage(language string) TranscriptionOption {
+	return func(fields map[string]string) error {
+		fields["language"] = language
+		return nil
+	}
+}
+
+// WithTranscriptionPrompt prompt string Optional;
+// A text to guide the style of the transcription or continue a previous audio segment.
+func WithTranscriptionPrompt(prompt string) TranscriptionOption {
+	return func(fields map[string]string) error {
+		fields["prompt"] = prompt
+		return nil
+	}
+}
+
+// WithTranscriptionResponseFormat response_format string Optional Defaults to json;
+// The format of the transcription, "json", "text", "srt", "verbose_json" or "vtt".
+func WithTranscriptionResponseFormat(format string) TranscriptionOption {
+	return func(fields map[string]string) error {
+		fields["response_format"] = format
+		return nil
+	}
+}
+
+// WithTranscriptionTemperature temperature number Optional Defaults to 0;
+// The sampling temperature, between 0 and 1.
+// It returns ErrOutOfRange if the temperature is not between 0 and 1.
+func WithTranscriptionTemperature(temperature float64) TranscriptionOption {
+	return func(fields map[string]string) error {
+		if err := checkRange("temperature", temperature, 0, 1); err != nil {
+			return err
+		}
+		fields["temperature"] = strconv.FormatFloat(temperature, 'f', -1, 64)
+		return nil
+	}
+}
+
+// Transcribe transcribes the audio into text with the model, such as "whisper-1", which is used if the model is empty.
+// The filename is sent with the audio and its extension tells the API the format, such as "speech.mp3".
+// The options configure the request, such as WithTranscriptionLanguage.
+func (c *Chat) Transcribe(ctx context.Context, audio io.Reader, filename, model string, opts ...TranscriptionOption) (*TranscriptionResponse, error) {
+	if model == "" {
+		model = defaultTranscriptionModel
+	}
+	fields := map[string]string{"model": model}
+	for _, opt := range opts {
+		if err := opt(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	// build the multipart body, buffered so that the request can be retried
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	statusCode, data, err := c.post(ctx, "/v1/audio/transcriptions", rawBody{writer.FormDataContentType(), body.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &TranscriptionResponse{}
+	switch fields["response_format"] {
+	case "text", "srt", "vtt":
+		res.Text = string(data)
+	default:
+		if err := json.Unmarshal(data, res); err != nil {
+			return nil, decodeError(statusCode, data, err)
+		}
+	}
+
+	return res, nil
+}
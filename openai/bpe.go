@@ -0,0 +1,427 @@
+// @file bpe.go
+// @brief Byte pair encoding of the tiktoken encodings cl100k_base and o200k_base. (https://github.com/openai/tiktoken)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The merge ranks of the encodings, as published by OpenAI at
+// https://openaipublic.blob.core.windows.net/encodings/, compressed with gzip.
+var (
+	//go:embed encodings/cl100k_base.tiktoken.gz
+	cl100kRanks []byte
+	//go:embed encodings/o200k_base.tiktoken.gz
+	o200kRanks []byte
+)
+
+// encoding is a byte pair encoding: text is split into pieces by a pattern,
+// then the bytes of each piece are merged into tokens in the order of their rank.
+type encoding struct {
+	// Name of the encoding, such as "cl100k_base"
+	name string
+	// Compressed merge ranks
+	data []byte
+	// Returns the end of the piece of text starting at start
+	split func(runes []rune, start int) int
+	// Loads ranks once
+	once sync.Once
+	// Rank of each token, by its bytes, set by once
+	ranks map[string]int
+	// Error of loading the ranks, set by once
+	err error
+}
+
+var (
+	// cl100kBase is the encoding of gpt-4 and gpt-3.5-turbo.
+	cl100kBase = &encoding{name: "cl100k_base", data: cl100kRanks, split: splitCL100K}
+	// o200kBase is the encoding of gpt-4o and the reasoning models.
+	o200kBase = &encoding{name: "o200k_base", data: o200kRanks, split: splitO200K}
+)
+
+// o200kModels are the prefixes of the models using o200k_base, the others use cl100k_base.
+var o200kModels = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"}
+
+// encodingForModel returns the encoding of the model. Models unknown to tiktoken, such as those of
+// other servers, use cl100k_base, the encoding of the default model gpt-3.5-turbo.
+func encodingForModel(model string) *encoding {
+	for _, prefix := range o200kModels {
+		if model == prefix || strings.HasPrefix(model, prefix+"-") {
+			return o200kBase
+		}
+	}
+
+	return cl100kBase
+}
+
+// load decodes the merge ranks: each line is a base64 token and its rank.
+func (e *encoding) load() error {
+	e.once.Do(func() {
+		reader, err := gzip.NewReader(bytes.NewReader(e.data))
+		if err != nil {
+			e.err = fmt.Errorf("encoding %s: %w", e.name, err)
+			return
+		}
+
+		ranks := make(map[string]int, 200000)
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			token, err := base64.StdEncoding.DecodeString(fields[0])
+			if err != nil {
+				e.err = fmt.Errorf("encoding %s: %w", e.name, err)
+				return
+			}
+			rank, err := strconv.Atoi(fields[1])
+			if err != nil {
+				e.err = fmt.Errorf("encoding %s: %w", e.name, err)
+				return
+			}
+			ranks[string(token)] = rank
+		}
+		if err := scanner.Err(); err != nil {
+			e.err = fmt.Errorf("encoding %s: %w", e.name, err)
+			return
+		}
+		e.ranks = ranks
+	})
+
+	return e.err
+}
+
+// encode returns the tokens of the text.
+func (e *encoding) encode(text string) ([]int, error) {
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+
+	var tokens []int
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		end := e.split(runes, start)
+		tokens = e.mergePiece([]byte(string(runes[start:end])), tokens)
+		start = end
+	}
+
+	return tokens, nil
+}
+
+// count returns the number of tokens of the text.
+func (e *encoding) count(text string) (int, error) {
+	tokens, err := e.encode(text)
+	return len(tokens), err
+}
+
+// mergePiece appends the tokens of the piece to tokens. Starting from single bytes, the adjacent parts
+// whose concatenation has the lowest rank are merged, the leftmost first, until no concatenation is a token.
+func (e *encoding) mergePiece(piece []byte, tokens []int) []int {
+	if rank, ok := e.ranks[string(piece)]; ok {
+		return append(tokens, rank)
+	}
+
+	// Each part starts at a byte and ends at the start of the next part.
+	n := len(piece)
+	next, prev := make([]int, n), make([]int, n)
+	removed := make([]bool, n)
+	for index := range piece {
+		next[index], prev[index] = index+1, index-1
+	}
+
+	// pairRank returns the rank of the part at start merged with the part after it.
+	pairRank := func(start int) (int, bool) {
+		if next[start] >= n {
+			return 0, false
+		}
+		rank, ok := e.ranks[string(piece[start:next[next[start]]])]
+		return rank, ok
+	}
+
+	merges := &mergeQueue{}
+	for index := range piece {
+		if rank, ok := pairRank(index); ok {
+			merges.items = append(merges.items, merge{rank: rank, start: index})
+		}
+	}
+	heap.Init(merges)
+
+	for merges.Len() > 0 {
+		m := heap.Pop(merges).(merge)
+		// Skip merges of parts that have changed since they were queued.
+		if removed[m.start] {
+			continue
+		}
+		if rank, ok := pairRank(m.start); !ok || rank != m.rank {
+			continue
+		}
+
+		following := next[m.start]
+		removed[following] = true
+		next[m.start] = next[following]
+		if next[m.start] < n {
+			prev[next[m.start]] = m.start
+		}
+		if rank, ok := pairRank(m.start); ok {
+			heap.Push(merges, merge{rank: rank, start: m.start})
+		}
+		if previous := prev[m.start]; previous >= 0 {
+			if rank, ok := pairRank(previous); ok {
+				heap.Push(merges, merge{rank: rank, start: previous})
+			}
+		}
+	}
+
+	for start := 0; start < n; start = next[start] {
+		tokens = append(tokens, e.ranks[string(piece[start:next[start]])])
+	}
+	return tokens
+}
+
+// merge is a candidate merge of the part at start with the part after it.
+type merge struct {
+	rank, start int
+}
+
+// mergeQueue orders the candidate merges by rank, then by position, implementing heap.Interface.
+type mergeQueue struct {
+	items []merge
+}
+
+func (q *mergeQueue) Len() int { return len(q.items) }
+
+func (q *mergeQueue) Less(i, j int) bool {
+	if q.items[i].rank != q.items[j].rank {
+		return q.items[i].rank < q.items[j].rank
+	}
+	return q.items[i].start < q.items[j].start
+}
+
+func (q *mergeQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *mergeQueue) Push(x interface{}) { q.items = append(q.items, x.(merge)) }
+
+func (q *mergeQueue) Pop() interface{} {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+// contractions are the contractions split from the word before them, in the order of the patterns.
+var contractions = []string{"s", "t", "re", "ve", "m", "ll", "d"}
+
+// contraction returns the length of the contraction starting at start, or 0 if there is none.
+func contraction(runes []rune, start int) int {
+	if start >= len(runes) || runes[start] != '\'' {
+		return 0
+	}
+	for _, suffix := range contractions {
+		end := start + 1 + len(suffix)
+		if end <= len(runes) && equalFold(runes[start+1:end], suffix) {
+			return end - start
+		}
+	}
+
+	return 0
+}
+
+// Character classes of the patterns, -1 being the end of the text.
+func isNewline(r rune) bool { return r == '\r' || r == '\n' }
+
+func isSpace(r rune) bool { return r >= 0 && unicode.IsSpace(r) }
+
+func isLetter(r rune) bool { return r >= 0 && unicode.IsLetter(r) }
+
+func isNumber(r rune) bool { return r >= 0 && unicode.IsNumber(r) }
+
+// isOther matches [^\s\p{L}\p{N}].
+func isOther(r rune) bool {
+	return r >= 0 && !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isPrefix matches [^\r\n\p{L}\p{N}], the optional character before a word.
+func isPrefix(r rune) bool {
+	return r >= 0 && !isNewline(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// runeAt returns the rune at the index, or -1 past the end of the text.
+func runeAt(runes []rune, index int) rune {
+	if index < len(runes) {
+		return runes[index]
+	}
+	return -1
+}
+
+// splitCL100K returns the end of the piece of text starting at start, following the cl100k_base pattern:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitCL100K(runes []rune, start int) int {
+	at := func(index int) rune { return runeAt(runes, index) }
+
+	if length := contraction(runes, start); length > 0 {
+		return start + length
+	}
+
+	// Words, with an optional leading character.
+	end := start
+	if isPrefix(at(end)) && isLetter(at(end+1)) {
+		end++
+	}
+	if isLetter(at(end)) {
+		for isLetter(at(end)) {
+			end++
+		}
+		return end
+	}
+
+	if isNumber(at(start)) {
+		return numberEnd(runes, start)
+	}
+	if end, ok := punctuationEnd(runes, start, false); ok {
+		return end
+	}
+	return spaceEnd(runes, start)
+}
+
+// splitO200K returns the end of the piece of text starting at start, following the o200k_base pattern:
+//
+//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//	\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// so that words are also split before capital letters, and contractions stay with their word.
+func splitO200K(runes []rune, start int) int {
+	at := func(index int) rune { return runeAt(runes, index) }
+	upper := func(r rune) bool {
+		return r >= 0 && unicode.In(r, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+	}
+	lower := func(r rune) bool {
+		return r >= 0 && unicode.In(r, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+	}
+	run := func(index int, class func(rune) bool) int {
+		for class(at(index)) {
+			index++
+		}
+		return index
+	}
+	// The optional leading character is tried first, then the word without it.
+	starts := []int{start}
+	if isPrefix(at(start)) {
+		starts = []int{start + 1, start}
+	}
+
+	// Words ending with lower case letters, after any upper case letters. The upper case letters
+	// are given back one by one until one of them is also a lower case letter.
+	for _, begin := range starts {
+		for split := run(begin, upper); split >= begin; split-- {
+			if lower(at(split)) {
+				end := run(split, lower)
+				return end + contraction(runes, end)
+			}
+		}
+	}
+
+	// Words of upper case letters, with any lower case letters after them.
+	for _, begin := range starts {
+		if upper(at(begin)) {
+			end := run(run(begin, upper), lower)
+			return end + contraction(runes, end)
+		}
+	}
+
+	if isNumber(at(start)) {
+		return numberEnd(runes, start)
+	}
+	if end, ok := punctuationEnd(runes, start, true); ok {
+		return end
+	}
+	return spaceEnd(runes, start)
+}
+
+// numberEnd returns the end of the number starting at start, matching \p{N}{1,3}.
+func numberEnd(runes []rune, start int) int {
+	end := start
+	for end < start+3 && isNumber(runeAt(runes, end)) {
+		end++
+	}
+	return end
+}
+
+// punctuationEnd returns the end of the punctuation starting at start, matching ` ?[^\s\p{L}\p{N}]+[\r\n]*`,
+// with slashes after it too if slash is true. The boolean is false if there is no punctuation.
+func punctuationEnd(runes []rune, start int, slash bool) (int, bool) {
+	at := func(index int) rune { return runeAt(runes, index) }
+
+	end := start
+	if at(end) == ' ' && isOther(at(end+1)) {
+		end++
+	}
+	if !isOther(at(end)) {
+		return 0, false
+	}
+	for isOther(at(end)) {
+		end++
+	}
+	for isNewline(at(end)) || slash && at(end) == '/' {
+		end++
+	}
+	return end, true
+}
+
+// spaceEnd returns the end of the whitespace starting at start, matching `\s*[\r\n]+|\s+(?!\S)|\s+`.
+func spaceEnd(runes []rune, start int) int {
+	at := func(index int) rune { return runeAt(runes, index) }
+
+	// Whitespace ending with newlines.
+	end := start
+	lastNewline := -1
+	for isSpace(at(end)) {
+		if isNewline(at(end)) {
+			lastNewline = end
+		}
+		end++
+	}
+	if lastNewline >= 0 {
+		return lastNewline + 1
+	}
+
+	// Whitespace, leaving the last space to the word after it.
+	if end-start > 1 && end < len(runes) {
+		return end - 1
+	}
+	if end == start {
+		// Not matched by any part of the pattern, which cannot happen; keep the character on its own.
+		return start + 1
+	}
+	return end
+}
+
+// equalFold reports whether the runes are equal to the lower case ASCII string, ignoring case.
+func equalFold(runes []rune, s string) bool {
+	if len(runes) != len(s) {
+		return false
+	}
+	for index := range runes {
+		if unicode.ToLower(runes[index]) != rune(s[index]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,114 @@
+// @file builder.go
+// @brief Fluent builder for configuring a Chat.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+// Builder configures a chat with chained calls, for example:
+//
+//	chat, err := openai.NewBuilder(key).Model("gpt-4o").Temperature(0.7).User("hi").Build()
+//
+// Each method calls the setter of the same name on the chat. The first error returned by
+// a setter stops the configuration and is returned by Build.
+type Builder struct {
+	// Chat being configured
+	chat *Chat
+	// First error returned by a setter
+	err error
+}
+
+// NewBuilder returns a builder of a new chat with the key.
+func NewBuilder(key string) *Builder {
+	c := &Chat{}
+	c.SetAuthorizationKey(key)
+	return &Builder{chat: c}
+}
+
+// Builder returns a builder that configures the chat itself, so existing chats can be configured fluently too.
+func (c *Chat) Builder() *Builder {
+	return &Builder{chat: c}
+}
+
+// apply calls the setter unless an earlier one failed.
+func (b *Builder) apply(set func(c *Chat) error) *Builder {
+	if b.err == nil {
+		b.err = set(b.chat)
+	}
+
+	return b
+}
+
+// With applies the options, such as WithModel and WithBaseURL.
+func (b *Builder) With(opts ...Option) *Builder {
+	for _, opt := range opts {
+		b.apply(opt)
+	}
+
+	return b
+}
+
+// Model sets the model, see Chat.SetModel.
+func (b *Builder) Model(model string) *Builder {
+	return b.With(WithModel(model))
+}
+
+// Temperature sets the sampling temperature, see Chat.SetTemperature.
+func (b *Builder) Temperature(temperature float64) *Builder {
+	return b.With(WithTemperature(temperature))
+}
+
+// TopP sets the nucleus sampling probability mass, see Chat.SetTopP.
+func (b *Builder) TopP(topP float64) *Builder {
+	return b.With(WithTopP(topP))
+}
+
+// MaxTokens sets the maximum number of tokens to generate, see Chat.SetMaxTokens.
+func (b *Builder) MaxTokens(maxTokens int) *Builder {
+	return b.With(WithMaxTokens(maxTokens))
+}
+
+// N sets the number of choices to generate, see Chat.SetN.
+func (b *Builder) N(n int) *Builder {
+	return b.With(WithN(n))
+}
+
+// System adds a system message, see Chat.AddMessageAsSystem.
+func (b *Builder) System(content string) *Builder {
+	return b.apply(func(c *Chat) error {
+		c.AddMessageAsSystem(content)
+		return nil
+	})
+}
+
+// SystemPrompt sets the system message that every conversation starts with, see Chat.SetSystemPrompt.
+func (b *Builder) SystemPrompt(prompt string) *Builder {
+	return b.With(WithSystemPrompt(prompt))
+}
+
+// User adds a user message, see Chat.AddMessageAsUser.
+func (b *Builder) User(content string) *Builder {
+	return b.apply(func(c *Chat) error {
+		c.AddMessageAsUser(content)
+		return nil
+	})
+}
+
+// Assistant adds an assistant message, see Chat.AddMessageAsAssistant.
+func (b *Builder) Assistant(content string) *Builder {
+	return b.apply(func(c *Chat) error {
+		c.AddMessageAsAssistant(content)
+		return nil
+	})
+}
+
+// Build returns the configured chat, or the first error returned by a setter.
+func (b *Builder) Build() (*Chat, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return b.chat, nil
+}
@@ -9,22 +9,51 @@
 package openai
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
+)
+
+// Role is the role of the author of a message.
+type Role string
+
+// Roles of messages.
+const (
+	// RoleSystem is the role of instructions to the model.
+	RoleSystem Role = "system"
+	// RoleDeveloper is the role of instructions to reasoning models such as o1, replacing RoleSystem.
+	RoleDeveloper Role = "developer"
+	// RoleUser is the role of the messages of the user.
+	RoleUser Role = "user"
+	// RoleAssistant is the role of the replies of the model.
+	RoleAssistant Role = "assistant"
+	// RoleTool is the role of the results of tool calls.
+	RoleTool Role = "tool"
 )
 
 // Message is the message struct.
 type Message struct {
-	// Role is the role of the message. Can be "user", "system" or "assistant".
-	Role string `json:"role"`
+	// Role is the role of the message, one of the Role constants such as RoleUser.
+	Role Role `json:"role"`
 	// Content is the content of the message.
 	Content string `json:"content"`
+	// Name is the name of the participant, to tell apart participants of the same role.
+	Name string `json:"name,omitempty"`
+	// Refusal is the reason the model declined the request, set instead of Content in assistant messages.
+	Refusal string `json:"refusal,omitempty"`
+	// Parts is the structured content of the message. When set, it is sent instead of Content.
+	Parts []ContentPart `json:"-"`
+	// ToolCalls is the tool calls requested by the model in an assistant message.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the ID of the tool call a tool message is the result of.
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
 // Usage is the usage object is used to represent the usage of the API.
@@ -35,6 +64,54 @@ type Usage struct {
 	CompletionTokens int `json:"completion_tokens"`
 	// TotalTokens is the total number of tokens used.
 	TotalTokens int `json:"total_tokens"`
+	// PromptTokensDetails is the breakdown of the prompt tokens.
+	PromptTokensDetails PromptTokensDetails `json:"prompt_tokens_details"`
+}
+
+// PromptTokensDetails is the breakdown of the prompt tokens of a request.
+type PromptTokensDetails struct {
+	// CachedTokens is the number of prompt tokens read from the prompt cache, which are billed at a discount.
+	// Prompts are cached automatically when their start matches a recent request, such as a long system message.
+	CachedTokens int `json:"cached_tokens"`
+	// AudioTokens is the number of audio input tokens of the prompt.
+	AudioTokens int `json:"audio_tokens"`
+}
+
+// add adds the token counts of other to the usage.
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.PromptTokensDetails.CachedTokens += other.PromptTokensDetails.CachedTokens
+	u.PromptTokensDetails.AudioTokens += other.PromptTokensDetails.AudioTokens
+}
+
+// TopLogprob is the log probability of one of the most likely tokens at a position.
+type TopLogprob struct {
+	// Token is the token.
+	Token string `json:"token"`
+	// Logprob is the log probability of the token.
+	Logprob float64 `json:"logprob"`
+	// Bytes is the UTF-8 bytes of the token, useful when a character spans several tokens.
+	Bytes []int `json:"bytes"`
+}
+
+// TokenLogprob is the log probability of a generated token.
+type TokenLogprob struct {
+	// Token is the token.
+	Token string `json:"token"`
+	// Logprob is the log probability of the token.
+	Logprob float64 `json:"logprob"`
+	// Bytes is the UTF-8 bytes of the token, useful when a character spans several tokens.
+	Bytes []int `json:"bytes"`
+	// TopLogprobs is the most likely tokens at the position of the token, see SetTopLogprobs.
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+// Logprobs is the log probability information of a choice.
+type Logprobs struct {
+	// Content is the log probabilities of the tokens of the message content.
+	Content []TokenLogprob `json:"content"`
 }
 
 // Choice is the choice object is used to represent a choice in a chat completion.
@@ -44,7 +121,10 @@ type Choice struct {
 	// Msg is the message object is used to represent a message in a conversation.
 	Msg Message `json:"message"`
 	// FinishReason is the reason the chat completion stopped.
+	// Can be "stop", "length", "tool_calls" or "content_filter".
 	FinishReason string `json:"finish_reason"`
+	// Logprobs is the log probabilities of the tokens, only set if SetLogprobs is enabled.
+	Logprobs *Logprobs `json:"logprobs"`
 }
 
 // ChatResponse is the chat completion object is used to represent a chat completion.
@@ -55,64 +135,222 @@ type ChatResponse struct {
 	Object string `json:"object"`
 	// Created is the timestamp of when the chat completion was created.
 	Created int `json:"created"`
-	// Model is the ID of the model used to generate the chat completion.
+	// Model is the ID of the model used to generate the chat completion, such as "gpt-4o-2024-08-06".
+	Model string `json:"model"`
+	// Choices is the list of chat completion choices.
 	Choices []Choice `json:"choices"`
 	// Usage is the usage object is used to represent the usage of the API.
 	Usages Usage `json:"usage"`
+	// SystemFingerprint is the backend configuration the model runs with.
+	// When it changes, outputs with the same seed may differ.
+	SystemFingerprint string `json:"system_fingerprint"`
+}
+
+// ResponseFormat is the format the model must output.
+type ResponseFormat struct {
+	// Type is the type of the format. Can be "text", "json_object" or "json_schema".
+	Type string `json:"type"`
+	// JSONSchema is the schema the output must match, only with type "json_schema".
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is the schema of a structured output, see SetJSONSchema.
+type JSONSchema struct {
+	// Name is the name of the schema. It may contain letters, digits, underscores and dashes.
+	Name string `json:"name"`
+	// Description is what the output is for, used by the model to decide how to respond.
+	Description string `json:"description,omitempty"`
+	// Schema is the JSON schema object of the output.
+	Schema json.RawMessage `json:"schema"`
+	// Strict is whether the output must match the schema exactly.
+	// Strict schemas support a subset of JSON schema, see the structured outputs guide.
+	Strict bool `json:"strict"`
 }
 
 // Chat is the chat data
 type Chat struct {
 	// Request data
 	data sync.Map
-	// Secret key
+	// Secret key, a *string that is nil until set
 	key atomic.Value
+	// Messages of the conversation, guarded by mutex
+	messages []Message
 	// Mutex
 	mutex sync.RWMutex
+	// Last response returned by the API
+	lastResponse atomic.Value
+	// Index plus one of the choice of the last response appended to the messages, 0 for none, guarded by mutex
+	replyIndex int
+	// Key of the appended choice, to check that it is still the message at its index, guarded by mutex
+	replyKey messageKey
+	// Rate limit state of the last response
+	lastRateLimit atomic.Value
+	// Whether Close has been called, accessed atomically
+	closed int32
+	// HTTP client, nil for the default client
+	httpClient *http.Client
+	// Transport created by SetProxy, the only one whose idle connections Close closes, not owned by clones
+	ownTransport *http.Transport
+	// Base URL of the API, empty for the OpenAI API
+	baseURL string
+	// Path of chat completions, empty for the default
+	chatPath string
+	// Azure OpenAI deployment, empty for the OpenAI API
+	azureDeployment string
+	// Azure OpenAI api-version query parameter
+	azureAPIVersion string
+	// OpenAI-Organization header, empty to omit
+	organization string
+	// OpenAI-Project header, empty to omit
+	project string
+	// Custom headers
+	headers http.Header
+	// Whether each request gets a new Idempotency-Key header
+	autoIdempotency bool
+	// Maximum number of retries of a failed request
+	maxRetries int
+	// Delay before the first retry
+	retryDelay time.Duration
+	// Timeout of each request, 0 for none
+	timeout time.Duration
+	// Whether streamed requests include the usage
+	streamUsage bool
+	// Number of times a broken stream is resumed, 0 for none
+	streamReconnects int
+	// Number of characters at which streamed content is cut off, 0 for none
+	streamMaxLength int
+	// Whether replies are not appended to the messages
+	noAutoAppend bool
+	// Directory exchanges are recorded to, empty for none
+	recordDir string
+	// Directory exchanges are replayed from, empty for none
+	replayDir string
+	// Whether to check the request against the model capabilities
+	validateModel bool
+	// Token budget of the messages, 0 for none
+	maxHistoryTokens int
+	// System message kept at the start of the messages, empty for none, guarded by mutex
+	systemPrompt string
+	// Token counts of the messages, replaced rather than modified, guarded by mutex
+	tokenCounts *tokenCache
+	// User-Agent of requests, empty for the default
+	userAgent string
+	// Transforms the messages of each request, nil for none
+	messageFilter func(message Message) Message
+	// Usage of all requests, guarded by mutex
+	usage Usage
+	// Usage of all requests by model, guarded by mutex
+	modelUsage map[string]Usage
+	// Called before each request is sent, in order
+	requestInterceptors []func(req *http.Request) error
+	// Called after each response is received, in order
+	responseInterceptors []func(resp *http.Response) error
+}
+
+// defaultModel is the model used when none is set.
+const defaultModel = "gpt-3.5-turbo"
+
+// packageModel is the model set with SetDefaultModel, a string.
+var packageModel atomic.Value
+
+// SetDefaultModel is used to set the model of every chat without its own model set with SetModel,
+// including chats created before. An empty model resets to "gpt-3.5-turbo".
+func SetDefaultModel(model string) {
+	packageModel.Store(model)
+}
+
+// currentDefaultModel returns the model used when none is set.
+func currentDefaultModel() string {
+	if model, _ := packageModel.Load().(string); model != "" {
+		return model
+	}
+
+	return defaultModel
 }
 
 // SetAuthorizationKey is used to set authorization key
+// Requests return ErrMissingAPIKey until it is set. An empty key sends no Authorization header,
+// for OpenAI-compatible servers that need no key.
 func (c *Chat) SetAuthorizationKey(key string) {
-	c.key.Store(key)
-	c.data.Store("model", "gpt-3.5-turbo")
+	c.key.Store(&key)
+}
+
+// SetModel model string Required;
+// ID of the model to use, such as "gpt-4o". Defaults to "gpt-3.5-turbo" if not set, see SetDefaultModel.
+func (c *Chat) SetModel(model string) {
+	c.data.Store("model", model)
+}
+
+func (c *Chat) addMessage(role Role, content string) {
+	c.appendMessage(Message{Role: role, Content: content})
 }
 
-func (c *Chat) addMessage(role, content string) {
+func (c *Chat) appendMessage(message Message) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if _, ok := c.data.Load("messages"); !ok {
-		c.data.Store("messages", []map[string]string{})
-	}
-	val, _ := c.data.Load("messages")
-	var messages []map[string]string = val.([]map[string]string)
-	messages = append(messages, map[string]string{
-		"role":    role,
-		"content": content,
-	})
-	c.data.Store("messages", messages)
+	c.messages = append(c.messages, message)
 }
 
 // AddMessage is used to add message to the chat.
-// The role can be "user", "system" or "assistant".
+// The role can be any of the Role constants, such as RoleUser.
+func (c *Chat) AddMessage(role Role, content string) {
+	c.addMessage(role, content)
+}
+
 func (c *Chat) AddMessageAsUser(content string) {
-	c.addMessage("user", content)
+	c.AddMessage(RoleUser, content)
 }
 
 func (c *Chat) AddMessageAsSystem(content string) {
-	c.addMessage("system", content)
+	c.AddMessage(RoleSystem, content)
 }
 
 func (c *Chat) AddMessageAsAssistant(content string) {
-	c.addMessage("assistant", content)
+	c.AddMessage(RoleAssistant, content)
+}
+
+// AddMessageAsDeveloper is used to add instructions for reasoning models such as o1,
+// which take developer messages instead of system messages. For o1-mini and o1-preview, which take neither,
+// the text of the message is sent at the start of the first user message.
+func (c *Chat) AddMessageAsDeveloper(content string) {
+	c.AddMessage(RoleDeveloper, content)
+}
+
+// AddMessageWithName is used to add a message from a named participant, for example one of several
+// users or agents of the same role. The name may contain letters, digits, underscores and dashes.
+func (c *Chat) AddMessageWithName(role Role, name, content string) {
+	c.appendMessage(Message{Role: role, Name: name, Content: content})
+}
+
+// AddMessageWithParts is used to add a message with structured content, such as text and images.
+// Use TextPart, ImageURLPart, InputAudioPart and FilePart to build the parts.
+func (c *Chat) AddMessageWithParts(role Role, parts ...ContentPart) {
+	c.appendMessage(Message{Role: role, Parts: parts})
+}
+
+// AddMessageAsUserWithImages is used to add a user message with text and images, for vision models such as gpt-4o.
+// Each image is an URL or a base64 data URL such as "data:image/jpeg;base64,...".
+func (c *Chat) AddMessageAsUserWithImages(text string, imageURLs []string) {
+	parts := []ContentPart{TextPart(text)}
+	for _, url := range imageURLs {
+		parts = append(parts, ImageURLPart(url, ""))
+	}
+	c.AddMessageWithParts(RoleUser, parts...)
 }
 
 // SetTemperature temperature number Optional Defaults to 1;
 // What sampling temperature to use, between 0 and 2. Higher values like 0.8 will make the output more random
 // while lower values like 0.2 will make it more focused and deterministic.
 // We generally recommend altering this or top_p but not both.
-func (c *Chat) SetTemperature(temperature float64) {
+// It returns ErrOutOfRange if the temperature is not between 0 and 2.
+func (c *Chat) SetTemperature(temperature float64) error {
+	if err := checkRange("temperature", temperature, 0, 2); err != nil {
+		return err
+	}
+
 	c.data.Store("temperature", temperature)
+	return nil
 }
 
 // SetTopP top.p number Optional Defaults to 1;
@@ -120,54 +358,116 @@ func (c *Chat) SetTemperature(temperature float64) {
 // where the model considers the results of the tokens with top_p probability mass.
 // So 0.1 means only the tokens comprising the top 10% probability mass are considered.
 // We generally recommend altering this or temperature but not both.
-func (c *Chat) SetTopP(topP float64) {
+// It returns ErrOutOfRange if topP is not between 0 and 1.
+func (c *Chat) SetTopP(topP float64) error {
+	if err := checkRange("top_p", topP, 0, 1); err != nil {
+		return err
+	}
+
 	c.data.Store("top_p", topP)
+	return nil
 }
 
 // SetN How many chat completion choices to generate for each input message.
-func (c *Chat) SetN(n int) {
+// Only one choice can be appended to the messages, so that the next request continues a single
+// conversation: with SetAutoAppend enabled, NewChat returns ErrMultipleChoices, while NewChatChoices
+// and NewChatText append the first choice. Use SelectChoice to keep another choice instead.
+// It returns ErrOutOfRange if n is less than 1.
+func (c *Chat) SetN(n int) error {
+	if n < 1 {
+		return fmt.Errorf("%w: n must be at least 1, got %d", ErrOutOfRange, n)
+	}
+
 	c.data.Store("n", n)
+	return nil
 }
 
 // SetStream stream boolean Optional Defaults to false.
 // If set, partial message deltas will be sent, like in ChatGPT.
 // Tokens will be sent as data-only server-sent events as they become available,
 // with the stream terminated by a data: [DONE] message.
+// NewChat cannot read a streamed response, use NewChatStream instead.
 func (c *Chat) SetStream(stream bool) {
 	c.data.Store("stream", stream)
 }
 
+// maxStop is the maximum number of stop sequences accepted by the API.
+const maxStop = 4
+
 // SetStopStr stop string or array Optional Defaults to null;
 // Up to 4 sequences where the API will stop generating further tokens.
+// SetStopStr and SetStopArr set the same parameter, so each replaces the sequences set by the other.
 func (c *Chat) SetStopStr(stop string) {
 	c.data.Store("stop", stop)
 }
 
 // SetStopArr stop string or array Optional Defaults to null;
 // Up to 4 sequences where the API will stop generating further tokens.
-func (c *Chat) SetStopArr(stop []string) {
-	c.data.Store("stop", stop)
+// It replaces the sequence set by SetStopStr. It returns ErrOutOfRange if there are more than 4 sequences.
+func (c *Chat) SetStopArr(stop []string) error {
+	if len(stop) > maxStop {
+		return fmt.Errorf("%w: stop must have at most %d sequences, got %d", ErrOutOfRange, maxStop, len(stop))
+	}
+
+	list := make([]string, len(stop))
+	copy(list, stop)
+	c.data.Store("stop", list)
+	return nil
 }
 
 // SetMaxTokens max_tokens integer Optional Defaults to inf;
 // The maximum number of tokens allowed for the generated answer.
 // By default, the number of tokens the model can return will be (4096 - prompt tokens).
-func (c *Chat) SetMaxTokens(maxTokens int) {
+// It returns ErrOutOfRange if maxTokens is less than 1.
+func (c *Chat) SetMaxTokens(maxTokens int) error {
+	if maxTokens < 1 {
+		return fmt.Errorf("%w: max_tokens must be at least 1, got %d", ErrOutOfRange, maxTokens)
+	}
+
 	c.data.Store("max_tokens", maxTokens)
+	return nil
+}
+
+// SetMaxCompletionTokens max_completion_tokens integer Optional Defaults to null;
+// The maximum number of tokens that can be generated, including reasoning tokens.
+// Reasoning models such as o1 reject max_tokens and require this parameter instead. Requests to them
+// send a max_tokens set with SetMaxTokens as max_completion_tokens, system messages as developer messages,
+// and leave out the sampling parameters they reject, such as temperature and top_p. As o1-mini and o1-preview
+// reject both system and developer messages, their text is put at the start of the first user message instead.
+// It returns ErrOutOfRange if maxTokens is less than 1.
+func (c *Chat) SetMaxCompletionTokens(maxTokens int) error {
+	if maxTokens < 1 {
+		return fmt.Errorf("%w: max_completion_tokens must be at least 1, got %d", ErrOutOfRange, maxTokens)
+	}
+
+	c.data.Store("max_completion_tokens", maxTokens)
+	return nil
 }
 
 // SetPresencePenalty presence_penalty number Optional Defaults to 0;
 // Number between -2.0 and 2.0. Positive values penalize new tokens based on whether they appear
 // in the text so far, increasing the model's likelihood to talk about new topics.
-func (c *Chat) SetPresencePenalty(presencePenalty float64) {
+// It returns ErrOutOfRange if the penalty is not between -2 and 2.
+func (c *Chat) SetPresencePenalty(presencePenalty float64) error {
+	if err := checkRange("presence_penalty", presencePenalty, -2, 2); err != nil {
+		return err
+	}
+
 	c.data.Store("presence_penalty", presencePenalty)
+	return nil
 }
 
 // SetFrequencyPenalty frequency_penalty number Optional Defaults to 0;
 // Number between -2.0 and 2.0. Positive values penalize new tokens based on their existing
 // frequency in the text so far, decreasing the model's likelihood to repeat the same line verbatim.
-func (c *Chat) SetFrequencyPenalty(frequencyPenalty float64) {
+// It returns ErrOutOfRange if the penalty is not between -2 and 2.
+func (c *Chat) SetFrequencyPenalty(frequencyPenalty float64) error {
+	if err := checkRange("frequency_penalty", frequencyPenalty, -2, 2); err != nil {
+		return err
+	}
+
 	c.data.Store("frequency_penalty", frequencyPenalty)
+	return nil
 }
 
 // SetLogitBias logit_bias map Optional Defaults to null;
@@ -177,8 +477,90 @@ func (c *Chat) SetFrequencyPenalty(frequencyPenalty float64) {
 // generated by the model prior to sampling. The exact effect will vary per model, but values
 // between -1 and 1 should decrease or increase likelihood of selection; values like -100 or 100
 // should result in a ban or exclusive selection of the relevant token.
-func (c *Chat) SetLogitBias(logitBias map[string]int) {
+// It returns ErrOutOfRange if a bias is not between -100 and 100.
+func (c *Chat) SetLogitBias(logitBias map[string]int) error {
+	for token, bias := range logitBias {
+		if bias < -100 || bias > 100 {
+			return fmt.Errorf("%w: logit_bias of token %s must be between -100 and 100, got %d", ErrOutOfRange, token, bias)
+		}
+	}
+
 	c.data.Store("logit_bias", logitBias)
+	return nil
+}
+
+// SetSeed seed integer Optional;
+// If specified, the system will make a best effort to sample deterministically, such that repeated
+// requests with the same seed and parameters should return the same result. Determinism is not guaranteed,
+// compare the SystemFingerprint of the responses to detect backend changes.
+func (c *Chat) SetSeed(seed int) {
+	c.data.Store("seed", seed)
+}
+
+// SetLogprobs logprobs boolean Optional Defaults to false;
+// Whether to return log probabilities of the output tokens or not. If true, returns the log probabilities
+// of each output token returned in the content of message, in Choice.Logprobs.
+func (c *Chat) SetLogprobs(logprobs bool) {
+	c.data.Store("logprobs", logprobs)
+}
+
+// SetTopLogprobs top_logprobs integer Optional;
+// An integer between 0 and 20 specifying the number of most likely tokens to return at each token position,
+// each with an associated log probability. SetLogprobs must be enabled if this parameter is used.
+// It returns ErrOutOfRange if n is not between 0 and 20.
+func (c *Chat) SetTopLogprobs(n int) error {
+	if n < 0 || n > 20 {
+		return fmt.Errorf("%w: top_logprobs must be between 0 and 20, got %d", ErrOutOfRange, n)
+	}
+
+	c.data.Store("top_logprobs", n)
+	return nil
+}
+
+// SetResponseFormat response_format object Optional;
+// An object specifying the format that the model must output.
+func (c *Chat) SetResponseFormat(format ResponseFormat) {
+	c.data.Store("response_format", format)
+}
+
+// SetResponseFormatJSON enables JSON mode, which guarantees the message the model generates is valid JSON.
+// The API requires the word "json" to appear in the messages when JSON mode is on, this is not checked here.
+// The output may be cut off if FinishReason is "length".
+func (c *Chat) SetResponseFormatJSON() {
+	c.SetResponseFormat(ResponseFormat{Type: "json_object"})
+}
+
+// SetJSONSchema enables structured outputs, which make the message the model generates match the schema,
+// so that it can be decoded into a Go struct. With strict, the output always matches the schema exactly.
+// The model may decline the request instead, then the message has a Refusal and no content.
+func (c *Chat) SetJSONSchema(name string, schema json.RawMessage, strict bool) {
+	c.SetResponseFormat(ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &JSONSchema{Name: name, Schema: schema, Strict: strict},
+	})
+}
+
+// SetStore store boolean Optional Defaults to false;
+// Whether to store the completion, so that it appears in the dashboard and can be used in evals and distillation.
+// The parameter is only sent once set.
+func (c *Chat) SetStore(store bool) {
+	c.data.Store("store", store)
+}
+
+// SetMetadata metadata map Optional;
+// Up to 16 key-value pairs attached to a stored completion, to filter completions in the dashboard.
+// The parameter is only sent once set. An empty map removes it.
+func (c *Chat) SetMetadata(metadata map[string]string) {
+	if len(metadata) == 0 {
+		c.data.Delete("metadata")
+		return
+	}
+
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	c.data.Store("metadata", copied)
 }
 
 // SetUser user string Optional;
@@ -187,48 +569,458 @@ func (c *Chat) SetUser(user string) {
 	c.data.Store("user", user)
 }
 
+// GetHistoryMessages returns a copy of the messages of the chat, or an empty slice if there are none.
+//
+// Deprecated: Use GetMessages, which keeps structured content and tool calls.
 func (c *Chat) GetHistoryMessages() []map[string]string {
-	val, _ := c.data.Load("messages")
-	var messages []map[string]string = val.([]map[string]string)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	messages := make([]map[string]string, 0, len(c.messages))
+	for _, message := range c.messages {
+		messages = append(messages, map[string]string{
+			"role":    string(message.Role),
+			"content": message.text(),
+		})
+	}
 	return messages
 }
 
-// NewChat GetOpenAIResponse is the function to get the response from the OpenAI API.
-func (c *Chat) NewChat() (*ChatResponse, error) {
-	urls := "https://api.openai.com/v1/chat/completions"
+// MessageCount returns the number of messages of the chat, 0 for a new chat.
+// Unlike GetMessages, it does not copy the messages.
+func (c *Chat) MessageCount() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return len(c.messages)
+}
 
+// GetMessages returns a copy of the messages of the chat.
+func (c *Chat) GetMessages() []Message {
 	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return copyMessages(c.messages)
+}
+
+// ClearMessages is used to remove all messages while keeping the key, model and other parameters.
+// If a system prompt is set with SetSystemPrompt, the messages are left with only the prompt,
+// even if it had been removed, so the next conversation starts with it.
+func (c *Chat) ClearMessages() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.messages, c.replyIndex = nil, 0
+	if c.systemPrompt != "" {
+		c.messages = []Message{{Role: RoleSystem, Content: c.systemPrompt}}
+	}
+}
+
+// SetSystemPrompt is used to set a system message that every conversation of the chat starts with,
+// for example to share the same instructions across chats created with NewBuilder or Clone.
+// The prompt is put at the start of the messages, replacing the previous prompt, and ClearMessages keeps it.
+// An empty prompt removes the previous prompt from the messages.
+func (c *Chat) SetSystemPrompt(prompt string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.systemPrompt != "" && len(c.messages) > 0 &&
+		c.messages[0].Role == RoleSystem && c.messages[0].Content == c.systemPrompt {
+		c.removeMessages(0, 1)
+	}
+	c.systemPrompt = prompt
+	if prompt != "" {
+		c.insertMessage(0, Message{Role: RoleSystem, Content: prompt})
+	}
+}
+
+// Reset is used to reuse the chat for another conversation, as if it were new: the messages, request
+// parameters such as the model and temperature, client settings, last response and cumulative usage are all cleared.
+// The key is kept if keepKey is true.
+func (c *Chat) Reset(keepKey bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	mapVal := map[string]interface{}{}
 	c.data.Range(func(key, value interface{}) bool {
-		mapVal[key.(string)] = value
+		c.data.Delete(key)
 		return true
 	})
+	if !keepKey {
+		c.key.Store((*string)(nil))
+	}
+	c.lastResponse.Store((*ChatResponse)(nil))
+	c.lastRateLimit.Store(RateLimitInfo{})
+	atomic.StoreInt32(&c.closed, 0)
+
+	c.messages, c.replyIndex = nil, 0
+	c.httpClient, c.ownTransport = nil, nil
+	c.baseURL, c.chatPath = "", ""
+	c.azureDeployment, c.azureAPIVersion = "", ""
+	c.organization, c.project = "", ""
+	c.headers, c.autoIdempotency = nil, false
+	c.maxRetries, c.retryDelay = 0, 0
+	c.timeout = 0
+	c.streamUsage = false
+	c.streamReconnects, c.streamMaxLength = 0, 0
+	c.noAutoAppend = false
+	c.recordDir, c.replayDir = "", ""
+	c.validateModel = false
+	c.maxHistoryTokens, c.tokenCounts = 0, nil
+	c.systemPrompt = ""
+	c.userAgent = ""
+	c.messageFilter = nil
+	c.usage, c.modelUsage = Usage{}, nil
+	c.requestInterceptors, c.responseInterceptors = nil, nil
+}
+
+// Clone returns an independent copy of the chat, with the same key, parameters and messages.
+// Changes to the clone, such as new messages, do not affect the chat and vice versa.
+func (c *Chat) Clone() *Chat {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
-	// convert to json
-	jsonBody, err := json.Marshal(mapVal)
+	clone := &Chat{
+		messages:         copyMessages(c.messages),
+		httpClient:       c.httpClient,
+		baseURL:          c.baseURL,
+		chatPath:         c.chatPath,
+		azureDeployment:  c.azureDeployment,
+		azureAPIVersion:  c.azureAPIVersion,
+		organization:     c.organization,
+		project:          c.project,
+		headers:          c.headers.Clone(),
+		autoIdempotency:  c.autoIdempotency,
+		maxRetries:       c.maxRetries,
+		retryDelay:       c.retryDelay,
+		timeout:          c.timeout,
+		streamUsage:      c.streamUsage,
+		streamReconnects: c.streamReconnects,
+		streamMaxLength:  c.streamMaxLength,
+		noAutoAppend:     c.noAutoAppend,
+		recordDir:        c.recordDir,
+		replayDir:        c.replayDir,
+		validateModel:    c.validateModel,
+		maxHistoryTokens: c.maxHistoryTokens,
+		tokenCounts:      c.tokenCounts,
+		systemPrompt:     c.systemPrompt,
+		userAgent:        c.userAgent,
+		messageFilter:    c.messageFilter,
+		// The capacity is capped so that adding an interceptor to either chat copies the slice.
+		requestInterceptors:  c.requestInterceptors[:len(c.requestInterceptors):len(c.requestInterceptors)],
+		responseInterceptors: c.responseInterceptors[:len(c.responseInterceptors):len(c.responseInterceptors)],
+	}
+	if key, ok := c.key.Load().(*string); ok {
+		clone.key.Store(key)
+	}
+	// The setters replace the stored values and never modify them, so they can be shared.
+	c.data.Range(func(key, value interface{}) bool {
+		clone.data.Store(key, value)
+		return true
+	})
+
+	return clone
+}
+
+// SelectChoice is used to keep another choice of the last response in the messages.
+// If a choice of the last response has been appended, by auto-append, CommitChoice or SelectChoice,
+// and is still in the messages, it is replaced with the message of the choice with the index;
+// otherwise the message is appended. It returns an error if the choice is a refusal, which cannot be kept.
+func (c *Chat) SelectChoice(index int) error {
+	res := c.LastResponse()
+	if res == nil {
+		return errors.New("no response")
+	}
+	choice := -1
+	for i := range res.Choices {
+		if res.Choices[i].Index == index {
+			choice = i
+		}
+	}
+	if choice < 0 {
+		return fmt.Errorf("choice %d out of range, the last response has %d choices", index, len(res.Choices))
+	}
+	if refusal := res.Choices[choice].Msg.Refusal; refusal != "" {
+		return fmt.Errorf("choice %d is a refusal: %s", index, refusal)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	message := replyMessage(res.Choices[choice].Msg)
+	position := c.committedReply()
+	if position >= 0 {
+		c.messages[position] = message
+	} else {
+		position = len(c.messages)
+		c.messages = append(c.messages, message)
+	}
+	c.commitReply(position)
+
+	return nil
+}
+
+// SetModelValidation is used to check the request against the capabilities of the model before sending it.
+// When enabled, NewChat returns an error such as "model gpt-3.5-turbo-0301 does not support vision"
+// instead of sending a request the API would reject. Unknown models are never rejected.
+func (c *Chat) SetModelValidation(enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.validateModel = enabled
+}
+
+// LastAssistantMessage returns the most recent assistant message in the history.
+// The boolean is false if the history has no assistant message.
+func (c *Chat) LastAssistantMessage() (Message, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for index := len(c.messages) - 1; index >= 0; index-- {
+		if c.messages[index].Role == RoleAssistant {
+			return c.messages[index], true
+		}
+	}
+
+	return Message{}, false
+}
+
+// LastResponse returns the most recent response received by NewChat, NewChatText or a stream.
+// For a stream, it is the response assembled from the chunks once the stream completes.
+// It returns nil if no request has succeeded yet. Complete does not update it.
+func (c *Chat) LastResponse() *ChatResponse {
+	res, _ := c.lastResponse.Load().(*ChatResponse)
+	return res
+}
+
+// LastUsage returns the token usage of the most recent response.
+// It returns a zero Usage if no request has succeeded yet.
+func (c *Chat) LastUsage() Usage {
+	if res := c.LastResponse(); res != nil {
+		return res.Usages
+	}
+
+	return Usage{}
+}
+
+// CumulativeUsage returns the token usage of all the requests of the chat since it was created or reset,
+// including Complete and streamed requests. Streamed requests only count if SetStreamIncludeUsage is enabled.
+func (c *Chat) CumulativeUsage() Usage {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.usage
+}
+
+// addUsage adds the usage of the response to the cumulative usage.
+func (c *Chat) addUsage(res *ChatResponse) {
+	model := res.Model
+	if model == "" {
+		model = c.model()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.usage.add(res.Usages)
+	if c.modelUsage == nil {
+		c.modelUsage = map[string]Usage{}
+	}
+	usage := c.modelUsage[model]
+	usage.add(res.Usages)
+	c.modelUsage[model] = usage
+}
+
+// model returns the model of the requests.
+func (c *Chat) model() string {
+	if model, ok := c.data.Load("model"); ok {
+		if model, ok := model.(string); ok {
+			return model
+		}
+	}
+
+	return currentDefaultModel()
+}
+
+// LastFinishReason returns the finish reason of the first choice of the most recent response,
+// such as "stop", or "length" if the reply was cut off by the token limit and may be continued.
+// It returns an empty string if no request has succeeded yet.
+func (c *Chat) LastFinishReason() string {
+	if res := c.LastResponse(); res != nil && len(res.Choices) > 0 {
+		return res.Choices[0].FinishReason
+	}
+
+	return ""
+}
+
+// NewChat GetOpenAIResponse is the function to get the response from the OpenAI API.
+func (c *Chat) NewChat() (*ChatResponse, error) {
+	return c.NewChatWithContext(context.Background())
+}
+
+// NewChatWithContext is like NewChat, but the request is cancelled when the context is done.
+// If the context ends before the response is read, the returned error wraps ctx.Err().
+//
+// With SetN above 1 and SetAutoAppend enabled, it returns ErrMultipleChoices without sending the request,
+// as only one choice can be appended. Use NewChatChoices and SelectChoice to keep the choice you prefer,
+// or disable SetAutoAppend and append the choice with CommitChoice.
+func (c *Chat) NewChatWithContext(ctx context.Context) (*ChatResponse, error) {
+	if err := c.checkChoices(); err != nil {
+		return nil, err
+	}
+
+	return c.newChat(ctx)
+}
+
+// checkChoices returns ErrMultipleChoices if several choices are requested while the reply is appended.
+func (c *Chat) checkChoices() error {
+	n, _ := c.data.Load("n")
+	if count, err := toInt(n); err == nil && count > 1 && c.autoAppend() {
+		return fmt.Errorf("%w: n is %d, use NewChatChoices and SelectChoice to keep one", ErrMultipleChoices, count)
+	}
+
+	return nil
+}
+
+// newChat sends the chat request, stores the response and appends the first choice if SetAutoAppend is enabled.
+func (c *Chat) newChat(ctx context.Context) (*ChatResponse, error) {
+	c.trimHistory()
+	res, err := c.send(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// create request
-	req, err := http.NewRequest("POST", urls, bytes.NewBuffer(jsonBody))
+	c.storeResponse(res)
+
+	if c.autoAppend() {
+		c.CommitResponse(res)
+	}
+
+	return res, nil
+}
+
+// storeResponse stores the response as the last response, none of whose choices has been appended yet.
+func (c *Chat) storeResponse(res *ChatResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastResponse.Store(res)
+	c.replyIndex = 0
+}
+
+// SetAutoAppend is used to choose whether NewChat appends the reply of the assistant to the messages.
+// It is enabled by default. When disabled, call CommitResponse to append a reply once you decide to keep it,
+// for example to regenerate a reply without the discarded attempt in the history.
+func (c *Chat) SetAutoAppend(enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.noAutoAppend = !enabled
+}
+
+// autoAppend reports whether replies are appended to the messages.
+func (c *Chat) autoAppend() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return !c.noAutoAppend
+}
+
+// CommitResponse is used to append the reply of the response to the messages.
+// The whole message is kept, including its tool calls, so that tool results can be sent back.
+// Only the first choice is kept, use SelectChoice to keep another one.
+// A refusal is not appended, as it has no content to continue the conversation from.
+func (c *Chat) CommitResponse(res *ChatResponse) {
+	if res == nil || len(res.Choices) == 0 {
+		return
+	}
+
+	c.CommitChoice(res.Choices[0])
+}
+
+// CommitChoice is used to append the message of the choice to the messages, for example
+// a choice returned by NewChatChoices with SetAutoAppend disabled. A refusal is not appended.
+func (c *Chat) CommitChoice(choice Choice) {
+	if choice.Msg.Refusal != "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.messages = append(c.messages, replyMessage(choice.Msg))
+	c.commitReply(len(c.messages) - 1)
+}
+
+// replyMessage returns a copy of the message of a choice to keep in the messages,
+// with its tool calls, so that tool results sent back refer to them.
+func replyMessage(message Message) Message {
+	reply := copyMessages([]Message{message})[0]
+	if reply.Role == "" {
+		reply.Role = RoleAssistant
+	}
+
+	return reply
+}
+
+// continuePrompt is the user message asking the model to continue a reply that was cut off.
+const continuePrompt = "Continue exactly where you stopped, without repeating anything."
+
+// Continue is used to continue the last reply when it was cut off by the token limit, that is when
+// LastFinishReason is "length". It asks the model to continue and appends the continuation to the
+// last assistant message, so that the history holds the reply as a single message. The request asking
+// to continue is not kept in the history. The response of the continuation is returned; if it is cut off
+// too, call Continue again.
+func (c *Chat) Continue(ctx context.Context) (*ChatResponse, error) {
+	if c.LastFinishReason() != "length" {
+		return nil, errors.New("the last reply was not cut off")
+	}
+
+	c.mutex.RLock()
+	messages := copyMessages(c.messages)
+	c.mutex.RUnlock()
+
+	if len(messages) == 0 || messages[len(messages)-1].Role != RoleAssistant {
+		return nil, errors.New("no assistant message to continue")
+	}
+	messages = append(messages, Message{Role: RoleUser, Content: continuePrompt})
+
+	res, err := c.send(ctx, map[string]interface{}{"messages": messages})
 	if err != nil {
 		return nil, err
 	}
 
-	// set authorization key
-	key := strings.Builder{}
-	key.WriteString("Bearer ")
-	key.WriteString(c.key.Load().(string))
+	c.storeResponse(res)
 
-	// set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", key.String())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if last := len(c.messages) - 1; last >= 0 && c.messages[last].Role == RoleAssistant && len(res.Choices) > 0 {
+		c.messages[last].Content += res.Choices[0].Msg.Content
+	}
+
+	return res, nil
+}
+
+// Complete sends the messages with the parameters of the chat, without reading or changing its messages.
+// Unlike NewChat, it is safe to call concurrently on the same chat, for example to fan out
+// several requests that share a configuration. LastResponse is not updated.
+func (c *Chat) Complete(ctx context.Context, messages []Message) (*ChatResponse, error) {
+	return c.send(ctx, map[string]interface{}{"messages": copyMessages(messages)})
+}
+
+// send sends the chat completions request and decodes the response.
+// The params are added to the request data for this request only.
+func (c *Chat) send(ctx context.Context, params map[string]interface{}) (*ChatResponse, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, params)
+	if err != nil {
+		return nil, err
+	}
 
 	// send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -237,32 +1029,127 @@ func (c *Chat) NewChat() (*ChatResponse, error) {
 	// read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
+	}
+
+	if !isSuccess(resp.StatusCode) {
+		return nil, newAPIError(resp.StatusCode, body)
 	}
 
 	res := &ChatResponse{}
-	err = json.Unmarshal(body, &res)
+	err = json.Unmarshal(body, res)
+	if err != nil {
+		return nil, decodeError(resp.StatusCode, body, err)
+	}
+
+	if res.Choices == nil {
+		// Some OpenAI-compatible servers report errors with a 2xx status.
+		if apiErr := parseErrorEnvelope(resp.StatusCode, body); apiErr != nil {
+			return nil, apiErr
+		}
+		// Others leave out choices they have no content for, which is only an error
+		// if the body is not a chat completion at all.
+		if res.ID == "" && res.Object == "" && res.Model == "" {
+			return nil, fmt.Errorf("no chat completion in response body with status %d: %q", resp.StatusCode, snippet(body))
+		}
+		res.Choices = []Choice{}
+	}
+
+	c.addUsage(res)
+	return res, nil
+}
+
+// BuildRequestBody returns the JSON body NewChat would send, without sending it,
+// for example to inspect or test how the request is built.
+func (c *Chat) BuildRequestBody() ([]byte, error) {
+	body, err := c.requestBody(nil)
 	if err != nil {
 		return nil, err
 	}
 
+	return json.Marshal(body)
+}
+
+// BuildRequest returns the request NewChat would send, including its URL and headers, without sending it.
+func (c *Chat) BuildRequest(ctx context.Context) (*http.Request, error) {
+	return c.newRequest(ctx, nil)
+}
+
+// newRequest creates the chat completions request from the request data.
+// The params are added to the request data for this request only.
+func (c *Chat) newRequest(ctx context.Context, params map[string]interface{}) (*http.Request, error) {
+	body, err := c.requestBody(params)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.RLock()
+	path := c.chatPath
 	c.mutex.RUnlock()
+	if path == "" {
+		path = defaultChatPath
+	}
 
-	if res.Choices == nil {
-		return nil, errors.New("no response")
+	return c.buildRequest(ctx, "POST", path, body)
+}
+
+// requestBody returns the request data with the messages, the params and the default model.
+func (c *Chat) requestBody(params map[string]interface{}) (map[string]interface{}, error) {
+	c.mutex.RLock()
+
+	mapVal := map[string]interface{}{}
+	c.data.Range(func(key, value interface{}) bool {
+		mapVal[key.(string)] = value
+		return true
+	})
+	messages := make([]Message, len(c.messages))
+	copy(messages, c.messages)
+	mapVal["messages"] = messages
+	validateModel, filter := c.validateModel, c.messageFilter
+
+	c.mutex.RUnlock()
+
+	for key, value := range params {
+		mapVal[key] = value
+	}
+	if filter != nil {
+		mapVal["messages"] = filterMessages(mapVal["messages"], filter)
+	}
+	if _, ok := mapVal["model"]; !ok {
+		mapVal["model"] = currentDefaultModel()
+	}
+	model, _ := mapVal["model"].(string)
+	if isReasoningModel(model) {
+		adaptReasoningParams(model, mapVal)
 	}
 
-	// Append message of assistant to the messages.
-	for index := range res.Choices {
-		c.AddMessageAsAssistant(res.Choices[index].Msg.Content)
+	if validateModel {
+		if err := checkModel(model, requestFeatures(mapVal)); err != nil {
+			return nil, err
+		}
 	}
 
-	return res, nil
+	return mapVal, nil
+}
+
+// contextError wraps the context error if the context ended, so callers can tell cancellation from network failures.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("request aborted: %w", ctxErr)
+	}
+
+	return err
 }
 
 // NewChatText Get the messages from the response.
+// Use LastFinishReason to tell whether the reply was cut off.
 func (c *Chat) NewChatText() ([]string, error) {
-	res, err := c.NewChat()
+	return c.NewChatTextWithContext(context.Background())
+}
+
+// NewChatTextWithContext is like NewChatText, with a context to cancel the request or set its deadline.
+func (c *Chat) NewChatTextWithContext(ctx context.Context) ([]string, error) {
+	res, err := c.newChat(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -274,3 +1161,25 @@ func (c *Chat) NewChatText() ([]string, error) {
 
 	return messages, nil
 }
+
+// NewChatChoices sends the chat request like NewChat and returns the choices in index order,
+// each with its message and finish reason, for example to compare the choices of SetN.
+// Unlike NewChat, it accepts SetN above 1 with SetAutoAppend enabled: the first choice is appended,
+// and SelectChoice replaces it with another one.
+func (c *Chat) NewChatChoices() ([]Choice, error) {
+	return c.NewChatChoicesWithContext(context.Background())
+}
+
+// NewChatChoicesWithContext is like NewChatChoices, with a context to cancel the request or set its deadline.
+func (c *Chat) NewChatChoicesWithContext(ctx context.Context) ([]Choice, error) {
+	res, err := c.newChat(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	choices := make([]Choice, len(res.Choices))
+	copy(choices, res.Choices)
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Index < choices[j].Index })
+
+	return choices, nil
+}
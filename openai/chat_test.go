@@ -0,0 +1,598 @@
+// @file chat_test.go
+// @brief Tests of the Chat API against a mock OpenAI-compatible server.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// newTestChat returns a chat whose requests are sent to a test server running the handler.
+func newTestChat(t *testing.T, handler http.HandlerFunc) *Chat {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c := &Chat{}
+	c.SetAuthorizationKey("test-key")
+	c.SetBaseURL(server.URL)
+	return c
+}
+
+// completionJSON returns a chat completion with one choice per reply.
+func completionJSON(replies ...string) string {
+	choices := make([]string, len(replies))
+	for index, reply := range replies {
+		content, _ := json.Marshal(reply)
+		choices[index] = fmt.Sprintf(`{"index":%d,"message":{"role":"assistant","content":%s},"finish_reason":"stop"}`, index, content)
+	}
+
+	return `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-3.5-turbo",` +
+		`"choices":[` + strings.Join(choices, ",") + `],` +
+		`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`
+}
+
+// writeEvents writes the data of each server-sent event, then the data: [DONE] message.
+func writeEvents(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		fmt.Fprintf(w, "data: %s\n\n", event)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// chunkJSON returns a chat completion chunk adding the content to the first choice.
+func chunkJSON(content, finishReason string) string {
+	delta, _ := json.Marshal(content)
+	reason := "null"
+	if finishReason != "" {
+		reason = `"` + finishReason + `"`
+	}
+
+	return `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-3.5-turbo",` +
+		`"choices":[{"index":0,"delta":{"content":` + string(delta) + `},"finish_reason":` + reason + `}]}`
+}
+
+// decodeRequest decodes the JSON body of the request.
+func decodeRequest(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Errorf("decode request body: %v", err)
+	}
+	return body
+}
+
+func TestNewChat(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request = %s %s, want POST /v1/chat/completions", r.Method, r.URL.Path)
+		}
+		body := decodeRequest(t, r)
+		if body["model"] != "gpt-3.5-turbo" {
+			t.Errorf("model = %v, want gpt-3.5-turbo", body["model"])
+		}
+		if messages, _ := body["messages"].([]interface{}); len(messages) != 1 {
+			t.Errorf("messages = %v, want the user message", body["messages"])
+		}
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.AddMessageAsUser("Hi")
+
+	res, err := c.NewChat()
+	if err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if len(res.Choices) != 1 || res.Choices[0].Msg.Content != "Hello!" {
+		t.Errorf("choices = %+v, want the reply", res.Choices)
+	}
+	if res.Usages.TotalTokens != 15 {
+		t.Errorf("total tokens = %d, want 15", res.Usages.TotalTokens)
+	}
+
+	messages := c.GetMessages()
+	if len(messages) != 2 || messages[1].Role != "assistant" || messages[1].Content != "Hello!" {
+		t.Errorf("messages = %+v, want the reply appended", messages)
+	}
+	if usage := c.CumulativeUsage(); usage.TotalTokens != 15 {
+		t.Errorf("cumulative tokens = %d, want 15", usage.TotalTokens)
+	}
+}
+
+func TestNewChatStream(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		if body := decodeRequest(t, r); body["stream"] != true {
+			t.Errorf("stream = %v, want true", body["stream"])
+		}
+		if accept := r.Header.Get("Accept"); accept != "text/event-stream" {
+			t.Errorf("Accept = %q, want text/event-stream", accept)
+		}
+		writeEvents(w, chunkJSON("Hel", ""), chunkJSON("lo!", "stop"))
+	})
+	c.AddMessageAsUser("Hi")
+
+	stream, err := c.NewChatStream(context.Background())
+	if err != nil {
+		t.Fatalf("NewChatStream: %v", err)
+	}
+	defer stream.Close()
+
+	content := strings.Builder{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+		}
+	}
+
+	if content.String() != "Hello!" {
+		t.Errorf("content = %q, want Hello!", content.String())
+	}
+	if reason := c.LastFinishReason(); reason != "stop" {
+		t.Errorf("finish reason = %q, want stop", reason)
+	}
+	if reply, ok := c.LastAssistantMessage(); !ok || reply.Content != "Hello!" {
+		t.Errorf("last assistant message = %+v, want the streamed reply", reply)
+	}
+}
+
+func TestNewChatAPIError(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`)
+	})
+	c.AddMessageAsUser("Hi")
+
+	_, err := c.NewChat()
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("NewChat error = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "invalid_api_key" {
+		t.Errorf("error = %+v, want status 401 and code invalid_api_key", apiErr)
+	}
+	if count := c.MessageCount(); count != 1 {
+		t.Errorf("message count = %d, want 1", count)
+	}
+}
+
+func TestNewChatStreamAPIError(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`)
+	})
+	c.AddMessageAsUser("Hi")
+
+	_, err := c.NewChatStream(context.Background())
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("NewChatStream error = %v, want an *APIError with status 429", err)
+	}
+}
+
+func TestNewChatErrorWithSuccessStatus(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"error":{"message":"model not loaded","type":"server_error"}}`)
+	})
+	c.AddMessageAsUser("Hi")
+
+	_, err := c.NewChat()
+	apiErr := &APIError{}
+	if !errors.As(err, &apiErr) || apiErr.Message != "model not loaded" {
+		t.Fatalf("NewChat error = %v, want the error of the body", err)
+	}
+}
+
+func TestNewChatWithoutChoices(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"local"}`)
+	})
+	c.AddMessageAsUser("Hi")
+
+	res, err := c.NewChat()
+	if err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if res.Choices == nil || len(res.Choices) != 0 {
+		t.Errorf("choices = %#v, want an empty slice", res.Choices)
+	}
+	if count := c.MessageCount(); count != 1 {
+		t.Errorf("message count = %d, want nothing appended", count)
+	}
+}
+
+func TestNewChatInvalidBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", "empty response body with status 200"},
+		{"null", "null", "no chat completion in response body with status 200"},
+		{"unknown object", `{"detail":"not found"}`, `"{\"detail\":\"not found\"}"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, test.body)
+			})
+			c.AddMessageAsUser("Hi")
+
+			_, err := c.NewChat()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("NewChat error = %v, want it to contain %s", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewChatLocalServer(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/chat/completions" {
+			t.Errorf("path = %s, want /api/v1/chat/completions", r.URL.Path)
+		}
+		if auth, ok := r.Header["Authorization"]; ok {
+			t.Errorf("Authorization = %q, want no header without a key", auth)
+		}
+		if body := decodeRequest(t, r); body["model"] != "llama" {
+			t.Errorf("model = %v, want llama", body["model"])
+		}
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.SetAuthorizationKey("")
+	c.SetChatCompletionsPath("api/v1/chat/completions")
+	c.SetModel("llama")
+	c.AddMessageAsUser("Hi")
+
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+}
+
+func TestLogitBiasSerialization(t *testing.T) {
+	c := &Chat{}
+	if err := c.SetLogitBias(map[string]int{"50256": -100, "100257": 100, "1734": 5}); err != nil {
+		t.Fatalf("SetLogitBias: %v", err)
+	}
+	c.SetSeed(12345678901)
+	if err := c.SetMaxTokens(1000000); err != nil {
+		t.Fatalf("SetMaxTokens: %v", err)
+	}
+
+	body, err := c.BuildRequestBody()
+	if err != nil {
+		t.Fatalf("BuildRequestBody: %v", err)
+	}
+	for _, want := range []string{
+		`"logit_bias":{"100257":100,"1734":5,"50256":-100}`,
+		`"seed":12345678901`,
+		`"max_tokens":1000000`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body = %s, want it to contain %s", body, want)
+		}
+	}
+	if strings.Contains(string(body), "e+") {
+		t.Errorf("body = %s, want integers without exponents", body)
+	}
+
+	if err := c.SetLogitBias(map[string]int{"50256": 101}); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("SetLogitBias error = %v, want ErrOutOfRange", err)
+	}
+}
+
+func TestConcurrentMessages(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+
+	const workers, rounds = 4, 20
+	done := make(chan error)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			for round := 0; round < rounds; round++ {
+				c.AddMessageAsUser("Hi")
+			}
+			done <- nil
+		}()
+		go func() {
+			for round := 0; round < rounds; round++ {
+				if _, err := c.NewChat(); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+		go func() {
+			for round := 0; round < rounds; round++ {
+				// The copies are the caller's to change.
+				for _, message := range c.GetHistoryMessages() {
+					message["content"] = "changed"
+				}
+				if messages := c.GetMessages(); len(messages) > 0 {
+					messages[0].Content = "changed"
+				}
+			}
+			done <- nil
+		}()
+	}
+	for index := 0; index < 3*workers; index++ {
+		if err := <-done; err != nil {
+			t.Fatalf("NewChat: %v", err)
+		}
+	}
+
+	messages := c.GetMessages()
+	if len(messages) != 2*workers*rounds {
+		t.Errorf("message count = %d, want %d", len(messages), 2*workers*rounds)
+	}
+	for _, message := range messages {
+		if message.Content == "changed" {
+			t.Fatal("changing the returned messages changed the messages of the chat")
+		}
+	}
+}
+
+func TestNewChatHTMLErrorPage(t *testing.T) {
+	const page = "<html><head><title>502 Bad Gateway</title></head><body><center><h1>502 Bad Gateway</h1></center></body></html>"
+	tests := []struct {
+		name   string
+		status int
+		want   []string
+	}{
+		{"bad gateway", http.StatusBadGateway, []string{"502", "Bad Gateway", "<title>502 Bad Gateway</title>"}},
+		{"success status", http.StatusOK, []string{"status 200", "<html>"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(test.status)
+				io.WriteString(w, page)
+			})
+			c.AddMessageAsUser("Hi")
+
+			_, err := c.NewChat()
+			if err == nil {
+				t.Fatal("NewChat succeeded, want an error")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("NewChat error = %v, want it to contain %q", err, want)
+				}
+			}
+			if test.status != http.StatusOK {
+				apiErr := &APIError{}
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != test.status {
+					t.Errorf("NewChat error = %v, want an *APIError with status %d", err, test.status)
+				}
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("é", maxSnippet)
+	got := snippet([]byte(long))
+	if !strings.HasSuffix(got, "...") || len(got) > maxSnippet+len("...") {
+		t.Errorf("snippet has %d bytes, want at most %d ending with ...", len(got), maxSnippet+len("..."))
+	}
+	if trimmed := strings.TrimSuffix(got, "..."); !strings.HasPrefix(long, trimmed) || !utf8.ValidString(trimmed) {
+		t.Errorf("snippet = %q, want whole characters from the start of the body", got)
+	}
+}
+
+func TestSystemPrompt(t *testing.T) {
+	c, err := NewBuilder("test-key").SystemPrompt("Be brief.").User("Hi").Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	c.AddMessageAsAssistant("Hello!")
+
+	clone := c.Clone()
+	c.SetSystemPrompt("Be polite.")
+	if messages := c.GetMessages(); len(messages) != 3 || messages[0].Content != "Be polite." || messages[1].Role != "user" {
+		t.Errorf("messages = %+v, want the new prompt replacing the old one", messages)
+	}
+
+	for _, chat := range []*Chat{c, clone} {
+		chat.ClearMessages()
+	}
+	if messages := c.GetMessages(); len(messages) != 1 || messages[0].Role != "system" || messages[0].Content != "Be polite." {
+		t.Errorf("messages after ClearMessages = %+v, want only the system prompt", messages)
+	}
+	if messages := clone.GetMessages(); len(messages) != 1 || messages[0].Content != "Be brief." {
+		t.Errorf("messages of the clone after ClearMessages = %+v, want only its system prompt", messages)
+	}
+
+	c.SetSystemPrompt("")
+	c.ClearMessages()
+	if count := c.MessageCount(); count != 0 {
+		t.Errorf("message count = %d after removing the prompt, want 0", count)
+	}
+	clone.Reset(true)
+	clone.ClearMessages()
+	if count := clone.MessageCount(); count != 0 {
+		t.Errorf("message count = %d after Reset, want 0", count)
+	}
+}
+
+func TestSelectChoice(t *testing.T) {
+	refusal := `{"id":"chatcmpl-1","object":"chat.completion","choices":[` +
+		`{"index":0,"message":{"role":"assistant","content":null,"refusal":"I can't help with that."},"finish_reason":"stop"},` +
+		`{"index":1,"message":{"role":"assistant","content":"B"},"finish_reason":"stop"}]}`
+	tests := []struct {
+		name       string
+		body       string
+		autoAppend bool
+		want       []string
+	}{
+		{"appended", completionJSON("A", "B"), true, []string{"old question", "old answer", "question", "B"}},
+		{"not appended", completionJSON("A", "B"), false, []string{"old question", "old answer", "question", "B"}},
+		{"refusal not appended", refusal, true, []string{"old question", "old answer", "question", "B"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, test.body)
+			})
+			c.SetAutoAppend(test.autoAppend)
+			c.AddMessageAsUser("old question")
+			c.AddMessageAsAssistant("old answer")
+			c.AddMessageAsUser("question")
+
+			if _, err := c.NewChat(); err != nil {
+				t.Fatalf("NewChat: %v", err)
+			}
+			// Selecting twice replaces the selected choice rather than appending it again.
+			for i := 0; i < 2; i++ {
+				if err := c.SelectChoice(1); err != nil {
+					t.Fatalf("SelectChoice: %v", err)
+				}
+			}
+
+			messages := c.GetMessages()
+			got := make([]string, len(messages))
+			for index := range messages {
+				got[index] = messages[index].Content
+			}
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Errorf("messages = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewChatChoicesWithContext(t *testing.T) {
+	requests := 0
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.AddMessageAsUser("Hi")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.NewChatChoicesWithContext(ctx); !errors.Is(err, context.Canceled) || requests != 0 {
+		t.Errorf("NewChatChoicesWithContext with a cancelled context = %v after %d requests, want context.Canceled", err, requests)
+	}
+	if choices, err := c.NewChatChoicesWithContext(context.Background()); err != nil || len(choices) != 1 {
+		t.Errorf("NewChatChoicesWithContext = %+v, %v, want one choice", choices, err)
+	}
+}
+
+func TestSelectChoiceRefusal(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[`+
+			`{"index":0,"message":{"role":"assistant","content":"A"},"finish_reason":"stop"},`+
+			`{"index":1,"message":{"role":"assistant","content":null,"refusal":"No."},"finish_reason":"stop"}]}`)
+	})
+	c.AddMessageAsUser("question")
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+
+	if err := c.SelectChoice(1); err == nil || !strings.Contains(err.Error(), "refusal") {
+		t.Errorf("SelectChoice of a refusal = %v, want an error", err)
+	}
+	if reply, _ := c.LastAssistantMessage(); reply.Content != "A" {
+		t.Errorf("reply = %q, want A kept", reply.Content)
+	}
+}
+
+func TestSelectChoiceAfterEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(c *Chat)
+		want []string
+	}{
+		{
+			name: "reply removed",
+			edit: func(c *Chat) { c.RemoveLastMessage() },
+			want: []string{"old question", "old answer", "question", "B"},
+		},
+		{
+			name: "exchange removed",
+			edit: func(c *Chat) { c.RemoveLastExchange() },
+			want: []string{"old question", "old answer", "B"},
+		},
+		{
+			name: "reply deleted",
+			edit: func(c *Chat) { c.DeleteMessageAt(-1) },
+			want: []string{"old question", "old answer", "question", "B"},
+		},
+		{
+			name: "history loaded",
+			edit: func(c *Chat) {
+				if err := c.LoadHistory([]byte(`[{"role":"user","content":"saved question"},{"role":"assistant","content":"saved answer"}]`)); err != nil {
+					t.Fatalf("LoadHistory: %v", err)
+				}
+			},
+			want: []string{"saved question", "saved answer", "B"},
+		},
+		{
+			name: "message inserted before the reply",
+			edit: func(c *Chat) { c.InsertMessageAt(0, "system", "Be brief.") },
+			want: []string{"Be brief.", "old question", "old answer", "question", "B"},
+		},
+		{
+			name: "system prompt set",
+			edit: func(c *Chat) { c.SetSystemPrompt("Be brief.") },
+			want: []string{"Be brief.", "old question", "old answer", "question", "B"},
+		},
+		{
+			name: "message added after the reply",
+			edit: func(c *Chat) { c.AddMessageAsUser("next question") },
+			want: []string{"old question", "old answer", "question", "B", "next question"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, completionJSON("A", "B"))
+			})
+			c.AddMessageAsUser("old question")
+			c.AddMessageAsAssistant("old answer")
+			c.AddMessageAsUser("question")
+			if _, err := c.NewChatChoices(); err != nil {
+				t.Fatalf("NewChatChoices: %v", err)
+			}
+
+			test.edit(c)
+			if err := c.SelectChoice(1); err != nil {
+				t.Fatalf("SelectChoice: %v", err)
+			}
+
+			messages := c.GetMessages()
+			got := make([]string, len(messages))
+			for index := range messages {
+				got[index] = messages[index].Content
+			}
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Errorf("messages = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
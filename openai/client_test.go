@@ -0,0 +1,461 @@
+// @file client_test.go
+// @brief Tests of the HTTP client configuration of OpenAI API requests.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildRequestURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Chat)
+		path  string
+		want  string
+	}{
+		{"default", func(c *Chat) {}, "/v1/chat/completions", "https://api.openai.com/v1/chat/completions"},
+		{"base URL", func(c *Chat) { c.SetBaseURL("https://gateway.example.com/openai/") }, "/v1/moderations",
+			"https://gateway.example.com/openai/v1/moderations"},
+		{"azure", func(c *Chat) { c.SetAzure("https://res.openai.azure.com/", "my gpt", "2024-06-01") }, "/v1/chat/completions",
+			"https://res.openai.azure.com/openai/deployments/my%20gpt/chat/completions?api-version=2024-06-01"},
+		{"azure reset", func(c *Chat) {
+			c.SetAzure("https://res.openai.azure.com", "gpt", "2024-06-01")
+			c.SetAzure("", "", "")
+		}, "/v1/chat/completions", "https://api.openai.com/v1/chat/completions"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Chat{}
+			c.SetAuthorizationKey("sk-test")
+			test.setup(c)
+
+			req, err := c.buildRequest(context.Background(), "POST", test.path, nil)
+			if err != nil {
+				t.Fatalf("buildRequest: %v", err)
+			}
+			if got := req.URL.String(); got != test.want {
+				t.Errorf("URL = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildRequestAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		azure      bool
+		wantBearer string
+		wantAPIKey string
+	}{
+		{"bearer", "sk-test", false, "Bearer sk-test", ""},
+		{"azure", "azure-key", true, "", "azure-key"},
+		{"empty key", "", false, "", ""},
+		{"empty key azure", "", true, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Chat{}
+			c.SetAuthorizationKey(test.key)
+			if test.azure {
+				c.SetAzure("https://res.openai.azure.com", "gpt", "2024-06-01")
+			}
+
+			req, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+			if err != nil {
+				t.Fatalf("buildRequest: %v", err)
+			}
+			if _, ok := req.Header["Authorization"]; ok != (test.wantBearer != "") {
+				t.Errorf("Authorization present = %v, want %v", ok, test.wantBearer != "")
+			}
+			if got := req.Header.Get("Authorization"); got != test.wantBearer {
+				t.Errorf("Authorization = %q, want %q", got, test.wantBearer)
+			}
+			if _, ok := req.Header["Api-Key"]; ok != (test.wantAPIKey != "") {
+				t.Errorf("api-key present = %v, want %v", ok, test.wantAPIKey != "")
+			}
+			if got := req.Header.Get("api-key"); got != test.wantAPIKey {
+				t.Errorf("api-key = %q, want %q", got, test.wantAPIKey)
+			}
+		})
+	}
+}
+
+func TestBuildRequestMissingKey(t *testing.T) {
+	c := &Chat{}
+	if _, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("buildRequest error = %v, want ErrMissingAPIKey", err)
+	}
+
+	c.SetAuthorizationKey("sk-test")
+	c.Reset(false)
+	if _, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("buildRequest error after Reset(false) = %v, want ErrMissingAPIKey", err)
+	}
+}
+
+func TestBuildRequestClosed(t *testing.T) {
+	c := &Chat{}
+	c.SetAuthorizationKey("sk-test")
+	c.Close()
+
+	if _, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil); !errors.Is(err, ErrChatClosed) {
+		t.Errorf("buildRequest error = %v, want ErrChatClosed", err)
+	}
+}
+
+func TestCloseConnections(t *testing.T) {
+	var mutex sync.Mutex
+	states := map[http.ConnState]int{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, completionJSON("Hello!"))
+	}))
+	closed := make(chan struct{}, 1)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		states[state]++
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	newChat := func() *Chat {
+		c := &Chat{}
+		c.SetAuthorizationKey("sk-test")
+		c.SetBaseURL(server.URL)
+		c.AddMessageAsUser("Hi")
+		return c
+	}
+
+	// Closing a chat of the shared client keeps the connections of the other chats.
+	shared, other := newChat(), newChat()
+	if _, err := shared.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	other.Close()
+	if _, err := shared.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	mutex.Lock()
+	if states[http.StateNew] != 1 || states[http.StateClosed] != 0 {
+		t.Errorf("%d connections opened and %d closed, want the shared connection reused", states[http.StateNew], states[http.StateClosed])
+	}
+	mutex.Unlock()
+
+	// The transport created by SetProxy belongs to the chat, so its idle connections are closed.
+	owned := newChat()
+	if err := owned.SetProxy(server.URL); err != nil {
+		t.Fatalf("SetProxy: %v", err)
+	}
+	owned.SetRecorder(t.TempDir())
+	if _, err := owned.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	owned.Close()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("the idle connection of the proxy transport was not closed")
+	}
+}
+
+func TestBuildRequestHeaders(t *testing.T) {
+	c := &Chat{}
+	c.SetAuthorizationKey("sk-test")
+	c.SetOrganization("org-1")
+	c.SetProject("proj-1")
+	c.SetRequestHeader("OpenAI-Beta", "assistants=v2")
+	c.SetRequestHeaders(map[string]string{"X-Gateway-Key": "gw", "Authorization": "ignored"})
+
+	req, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	want := map[string]string{
+		"Authorization":       "Bearer sk-test",
+		"OpenAI-Organization": "org-1",
+		"OpenAI-Project":      "proj-1",
+		"OpenAI-Beta":         "assistants=v2",
+		"X-Gateway-Key":       "gw",
+	}
+	for name, value := range want {
+		if got := req.Header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	c.SetOrganization("")
+	c.SetRequestHeader("OpenAI-Beta", "")
+	c.SetRequestHeader("Authorization", "Bearer gateway-token")
+	req, err = c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	for _, name := range []string{"OpenAI-Organization", "OpenAI-Beta"} {
+		if _, ok := req.Header[http.CanonicalHeaderKey(name)]; ok {
+			t.Errorf("%s is sent after being removed", name)
+		}
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer gateway-token" {
+		t.Errorf("Authorization = %q, want the custom header to replace the key", got)
+	}
+}
+
+func TestBuildRequestBody(t *testing.T) {
+	c := &Chat{}
+	c.SetAuthorizationKey("sk-test")
+
+	tests := []struct {
+		name            string
+		body            interface{}
+		wantBody        string
+		wantContentType string
+	}{
+		{"nil", nil, "", ""},
+		{"json", map[string]interface{}{"input": "hi"}, `{"input":"hi"}`, "application/json"},
+		{"raw", rawBody{"multipart/form-data; boundary=x", []byte("--x--")}, "--x--", "multipart/form-data; boundary=x"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := c.buildRequest(context.Background(), "POST", "/v1/moderations", test.body)
+			if err != nil {
+				t.Fatalf("buildRequest: %v", err)
+			}
+			if got := req.Header.Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, test.wantContentType)
+			}
+			body := ""
+			if req.Body != nil {
+				data, _ := io.ReadAll(req.Body)
+				body = string(data)
+			}
+			if body != test.wantBody {
+				t.Errorf("body = %q, want %q", body, test.wantBody)
+			}
+			if test.body != nil && req.GetBody == nil {
+				t.Error("GetBody is nil, so the request cannot be retried")
+			}
+		})
+	}
+}
+
+func TestBuildRequestIdempotencyKey(t *testing.T) {
+	c := &Chat{}
+	c.SetAuthorizationKey("sk-test")
+	c.SetAutoIdempotencyKey(true)
+
+	first, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	second, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	if key := first.Header.Get(idempotencyHeader); key == "" || key == second.Header.Get(idempotencyHeader) {
+		t.Errorf("Idempotency-Key = %q then %q, want a new key for each request", key, second.Header.Get(idempotencyHeader))
+	}
+
+	c.SetIdempotencyKey("fixed")
+	req, err := c.buildRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	if key := req.Header.Get(idempotencyHeader); key != "fixed" {
+		t.Errorf("Idempotency-Key = %q, want the key set with SetIdempotencyKey", key)
+	}
+}
+
+func TestRequestHeaderOnEveryEndpoint(t *testing.T) {
+	received := map[string]string{}
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if r.Header.Get("Accept") == "text/event-stream" {
+			name += " (stream)"
+		}
+		received[name] = r.Header.Get("X-Gateway-Key")
+
+		switch {
+		case r.Header.Get("Accept") == "text/event-stream":
+			writeEvents(w, chunkJSON("Hello!", "stop"))
+		case r.URL.Path == "/v1/chat/completions":
+			io.WriteString(w, completionJSON("Hello!"))
+		case r.URL.Path == "/v1/completions":
+			io.WriteString(w, `{"choices":[{"text":"Hello!"}]}`)
+		case r.URL.Path == "/v1/moderations":
+			io.WriteString(w, `{"results":[{"flagged":false}]}`)
+		case r.URL.Path == "/v1/images/generations":
+			io.WriteString(w, `{"data":[{"url":"https://example.com/image.png"}]}`)
+		case r.URL.Path == "/v1/audio/transcriptions":
+			io.WriteString(w, `{"text":"Hello!"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	c.SetRequestHeader("X-Gateway-Key", "gw")
+	c.AddMessageAsUser("Hi")
+	ctx := context.Background()
+
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	stream, err := c.NewChatStream(ctx)
+	if err != nil {
+		t.Fatalf("NewChatStream: %v", err)
+	}
+	stream.Close()
+	if _, err := c.NewCompletion().Do(ctx); err != nil {
+		t.Fatalf("Completion.Do: %v", err)
+	}
+	if _, err := c.Moderate("Hi"); err != nil {
+		t.Fatalf("Moderate: %v", err)
+	}
+	if _, err := c.NewImages().Generate(ctx, "a cat"); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if _, err := c.Transcribe(ctx, strings.NewReader("audio"), "speech.mp3", ""); err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+
+	for _, name := range []string{
+		"/v1/chat/completions", "/v1/chat/completions (stream)", "/v1/completions",
+		"/v1/moderations", "/v1/images/generations", "/v1/audio/transcriptions",
+	} {
+		if got, ok := received[name]; !ok || got != "gw" {
+			t.Errorf("X-Gateway-Key of %s = %q, want gw", name, got)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	if os.Getenv("WIND_CHIMES_PROXY_TEST") == "1" {
+		// Child process started below: the proxy environment is read once per process.
+		c := &Chat{}
+		c.SetAuthorizationKey("sk-test")
+		c.AddMessageAsUser("Hi")
+		c.NewChat()
+		return
+	}
+
+	connects := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			select {
+			case connects <- r.Host:
+			default:
+			}
+		}
+		http.Error(w, "refused by the test proxy", http.StatusForbidden)
+	}))
+	defer proxy.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestProxyFromEnvironment$")
+	cmd.Env = append(os.Environ(), "WIND_CHIMES_PROXY_TEST=1",
+		"HTTPS_PROXY="+proxy.URL, "https_proxy="+proxy.URL, "NO_PROXY=", "no_proxy=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child process: %v\n%s", err, out)
+	}
+
+	select {
+	case host := <-connects:
+		if host != "api.openai.com:443" {
+			t.Errorf("proxied host = %s, want api.openai.com:443", host)
+		}
+	default:
+		t.Error("the request was not sent through HTTPS_PROXY")
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	agents := make(chan string, 2)
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.AddMessageAsUser("Hi")
+
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if ua := <-agents; ua != "wind-chimes/"+Version {
+		t.Errorf("default User-Agent = %q, want wind-chimes/%s", ua, Version)
+	}
+
+	c.SetUserAgent("my-app/1.2")
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if ua := <-agents; ua != "my-app/1.2" {
+		t.Errorf("User-Agent = %q, want my-app/1.2", ua)
+	}
+
+	c.SetUserAgent("")
+	req, err := c.BuildRequest(context.Background())
+	if err != nil {
+		t.Fatalf("BuildRequest: %v", err)
+	}
+	if ua := req.Header.Get("User-Agent"); ua != defaultUserAgent {
+		t.Errorf("User-Agent after reset = %q, want %s", ua, defaultUserAgent)
+	}
+}
+
+func BenchmarkSequentialRequests(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, completionJSON("Hello!"))
+	}))
+	defer server.Close()
+
+	newChat := func() *Chat {
+		c := &Chat{}
+		c.SetAuthorizationKey("sk-test")
+		c.SetBaseURL(server.URL)
+		c.SetAutoAppend(false)
+		c.AddMessageAsUser("Hi")
+		return c
+	}
+
+	// The shared client keeps the connection alive between requests.
+	b.Run("shared client", func(b *testing.B) {
+		c := newChat()
+		for index := 0; index < b.N; index++ {
+			if _, err := c.NewChat(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// A new client per request opens a new connection each time, as the client did before it was shared.
+	b.Run("client per request", func(b *testing.B) {
+		c := newChat()
+		for index := 0; index < b.N; index++ {
+			transport := newTransport()
+			c.SetHTTPClient(&http.Client{Transport: transport})
+			if _, err := c.NewChat(); err != nil {
+				b.Fatal(err)
+			}
+			transport.CloseIdleConnections()
+		}
+	})
+}
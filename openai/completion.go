@@ -0,0 +1,166 @@
+// @file completion.go
+// @brief Completions (legacy) API implementation for OpenAI API. (https://platform.openai.com/docs/api-reference/completions)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// defaultCompletionModel is the model used by completions when none is set.
+const defaultCompletionModel = "gpt-3.5-turbo-instruct"
+
+// CompletionChoice is the choice object is used to represent a choice in a completion.
+type CompletionChoice struct {
+	// Text is the generated text.
+	Text string `json:"text"`
+	// The index of the choice.
+	Index int `json:"index"`
+	// FinishReason is the reason the completion stopped. Can be "stop", "length" or "content_filter".
+	FinishReason string `json:"finish_reason"`
+}
+
+// CompletionResponse is the completion object is used to represent a completion.
+type CompletionResponse struct {
+	// ID is the ID of the completion.
+	ID string `json:"id"`
+	// Object is the object type of the completion.
+	Object string `json:"object"`
+	// Created is the timestamp of when the completion was created.
+	Created int `json:"created"`
+	// Model is the ID of the model used to generate the completion.
+	Model string `json:"model"`
+	// Choices is the list of completion choices.
+	Choices []CompletionChoice `json:"choices"`
+	// Usage is the usage object is used to represent the usage of the API.
+	Usages Usage `json:"usage"`
+}
+
+// Completion is the completion data, for instruct models that take a plain prompt instead of messages.
+type Completion struct {
+	// Request data
+	data sync.Map
+	// Chat the key, base URL and other request settings are taken from
+	chat *Chat
+}
+
+// NewCompletion returns a completion that sends requests with the key, base URL, client,
+// retry policy and other request settings of the chat. Later changes to these settings apply too.
+func (c *Chat) NewCompletion() *Completion {
+	return &Completion{chat: c}
+}
+
+// SetModel model string Required;
+// ID of the model to use. Defaults to "gpt-3.5-turbo-instruct" if not set.
+func (c *Completion) SetModel(model string) {
+	c.data.Store("model", model)
+}
+
+// SetPrompt prompt string Required;
+// The prompt to generate completions for.
+func (c *Completion) SetPrompt(prompt string) {
+	c.data.Store("prompt", prompt)
+}
+
+// SetSuffix suffix string Optional Defaults to null;
+// The suffix that comes after a completion of inserted text.
+func (c *Completion) SetSuffix(suffix string) {
+	c.data.Store("suffix", suffix)
+}
+
+// SetMaxTokens max_tokens integer Optional Defaults to 16;
+// The maximum number of tokens to generate in the completion.
+// It returns ErrOutOfRange if maxTokens is less than 1.
+func (c *Completion) SetMaxTokens(maxTokens int) error {
+	if maxTokens < 1 {
+		return fmt.Errorf("%w: max_tokens must be at least 1, got %d", ErrOutOfRange, maxTokens)
+	}
+
+	c.data.Store("max_tokens", maxTokens)
+	return nil
+}
+
+// SetTemperature temperature number Optional Defaults to 1;
+// What sampling temperature to use, between 0 and 2, see Chat.SetTemperature.
+// It returns ErrOutOfRange if the temperature is not between 0 and 2.
+func (c *Completion) SetTemperature(temperature float64) error {
+	if err := checkRange("temperature", temperature, 0, 2); err != nil {
+		return err
+	}
+
+	c.data.Store("temperature", temperature)
+	return nil
+}
+
+// SetTopP top_p number Optional Defaults to 1;
+// An alternative to sampling with temperature, see Chat.SetTopP.
+// It returns ErrOutOfRange if topP is not between 0 and 1.
+func (c *Completion) SetTopP(topP float64) error {
+	if err := checkRange("top_p", topP, 0, 1); err != nil {
+		return err
+	}
+
+	c.data.Store("top_p", topP)
+	return nil
+}
+
+// SetN How many completions to generate for each prompt.
+// It returns ErrOutOfRange if n is less than 1.
+func (c *Completion) SetN(n int) error {
+	if n < 1 {
+		return fmt.Errorf("%w: n must be at least 1, got %d", ErrOutOfRange, n)
+	}
+
+	c.data.Store("n", n)
+	return nil
+}
+
+// SetStopArr stop string or array Optional Defaults to null;
+// Up to 4 sequences where the API will stop generating further tokens.
+// It returns ErrOutOfRange if there are more than 4 sequences.
+func (c *Completion) SetStopArr(stop []string) error {
+	if len(stop) > maxStop {
+		return fmt.Errorf("%w: stop must have at most %d sequences, got %d", ErrOutOfRange, maxStop, len(stop))
+	}
+
+	list := make([]string, len(stop))
+	copy(list, stop)
+	c.data.Store("stop", list)
+	return nil
+}
+
+// SetUser user string Optional;
+// A unique identifier representing your end-user, which can help OpenAI to monitor and detect abuse.
+func (c *Completion) SetUser(user string) {
+	c.data.Store("user", user)
+}
+
+// Do sends the completion request.
+func (c *Completion) Do(ctx context.Context) (*CompletionResponse, error) {
+	mapVal := map[string]interface{}{}
+	c.data.Range(func(key, value interface{}) bool {
+		mapVal[key.(string)] = value
+		return true
+	})
+	if _, ok := mapVal["model"]; !ok {
+		mapVal["model"] = defaultCompletionModel
+	}
+
+	res := &CompletionResponse{}
+	if err := c.chat.postJSON(ctx, "/v1/completions", mapVal, res); err != nil {
+		return nil, err
+	}
+
+	if res.Choices == nil {
+		return nil, errors.New("no response")
+	}
+
+	return res, nil
+}
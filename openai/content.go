@@ -0,0 +1,139 @@
+// @file content.go
+// @brief Structured message content for OpenAI Chat API. (https://platform.openai.com/docs/api-reference/chat/create)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Content part types.
+const (
+	// PartText is a plain text part.
+	PartText = "text"
+	// PartImageURL is an image given by URL or base64 data URL.
+	PartImageURL = "image_url"
+	// PartInputAudio is a base64 encoded audio clip.
+	PartInputAudio = "input_audio"
+	// PartFile is a file given by uploaded file ID or inline data.
+	PartFile = "file"
+)
+
+// ImageURL is the image object of an image_url content part.
+type ImageURL struct {
+	// URL is the image URL or a base64 data URL.
+	URL string `json:"url"`
+	// Detail is the detail level of the image. Can be "auto", "low" or "high".
+	Detail string `json:"detail,omitempty"`
+}
+
+// InputAudio is the audio object of an input_audio content part.
+type InputAudio struct {
+	// Data is the base64 encoded audio data.
+	Data string `json:"data"`
+	// Format is the format of the audio data. Can be "wav" or "mp3".
+	Format string `json:"format"`
+}
+
+// File is the file object of a file content part.
+type File struct {
+	// FileID is the ID of an uploaded file.
+	FileID string `json:"file_id,omitempty"`
+	// Filename is the name of the file, used with FileData.
+	Filename string `json:"filename,omitempty"`
+	// FileData is the base64 encoded file data.
+	FileData string `json:"file_data,omitempty"`
+}
+
+// ContentPart is one part of a message whose content is an array.
+type ContentPart struct {
+	// Type is the type of the part. Can be "text", "image_url", "input_audio" or "file".
+	Type string `json:"type"`
+	// Text is the text of a text part.
+	Text string `json:"text,omitempty"`
+	// ImageURL is the image of an image_url part.
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+	// InputAudio is the audio of an input_audio part.
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+	// File is the file of a file part.
+	File *File `json:"file,omitempty"`
+}
+
+// TextPart returns a text content part.
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+// ImageURLPart returns an image_url content part.
+// The detail can be "auto", "low", "high" or empty for the API default.
+func ImageURLPart(url, detail string) ContentPart {
+	return ContentPart{Type: PartImageURL, ImageURL: &ImageURL{URL: url, Detail: detail}}
+}
+
+// InputAudioPart returns an input_audio content part from base64 encoded data.
+// The format can be "wav" or "mp3".
+func InputAudioPart(data, format string) ContentPart {
+	return ContentPart{Type: PartInputAudio, InputAudio: &InputAudio{Data: data, Format: format}}
+}
+
+// FilePart returns a file content part.
+// Set either the file ID of an uploaded file, or the filename together with base64 encoded data.
+func FilePart(file File) ContentPart {
+	return ContentPart{Type: PartFile, File: &file}
+}
+
+// MarshalJSON encodes the content as a plain string, or as an array of parts when Parts is set.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type alias Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(alias(m))
+	}
+
+	return json.Marshal(struct {
+		alias
+		Content []ContentPart `json:"content"`
+	}{alias(m), m.Parts})
+}
+
+// UnmarshalJSON decodes the content from either a plain string or an array of parts.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type alias Message
+	aux := struct {
+		*alias
+		Content json.RawMessage `json:"content"`
+	}{alias: (*alias)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.Content, m.Parts = "", nil
+	if len(aux.Content) == 0 || string(aux.Content) == "null" {
+		return nil
+	}
+	if aux.Content[0] == '[' {
+		return json.Unmarshal(aux.Content, &m.Parts)
+	}
+
+	return json.Unmarshal(aux.Content, &m.Content)
+}
+
+// text returns the plain text of the message, joining the text parts if the content is structured.
+func (m Message) text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+
+	text := strings.Builder{}
+	for index := range m.Parts {
+		if m.Parts[index].Type == PartText {
+			text.WriteString(m.Parts[index].Text)
+		}
+	}
+
+	return text.String()
+}
@@ -0,0 +1,88 @@
+// @file cost.go
+// @brief Cost estimation from token usage. (https://openai.com/api/pricing)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// price is the price in dollars of 1000 tokens of a model.
+type price struct {
+	prompt     float64
+	completion float64
+}
+
+var (
+	// pricing is the price table of known models, by model. Dated snapshots without
+	// their own entry use the entry of their base model.
+	pricing = map[string]price{
+		"gpt-3.5-turbo":     {prompt: 0.0005, completion: 0.0015},
+		"gpt-4":             {prompt: 0.03, completion: 0.06},
+		"gpt-4-turbo":       {prompt: 0.01, completion: 0.03},
+		"gpt-4o":            {prompt: 0.0025, completion: 0.01},
+		"gpt-4o-2024-05-13": {prompt: 0.005, completion: 0.015},
+		"gpt-4o-mini":       {prompt: 0.00015, completion: 0.0006},
+		"o1":                {prompt: 0.015, completion: 0.06},
+		"o1-mini":           {prompt: 0.0011, completion: 0.0044},
+		"o3-mini":           {prompt: 0.0011, completion: 0.0044},
+	}
+	// pricingMutex guards pricing.
+	pricingMutex sync.RWMutex
+)
+
+// SetPricing is used to set the price in dollars of 1000 prompt and completion tokens of the model,
+// used by EstimateCost, for models missing from the built-in prices or negotiated rates.
+// The price applies to the dated snapshots of the model too, unless they have their own price.
+func SetPricing(model string, promptPer1K, completionPer1K float64) {
+	pricingMutex.Lock()
+	defer pricingMutex.Unlock()
+
+	pricing[model] = price{prompt: promptPer1K, completion: completionPer1K}
+}
+
+// lookupPrice returns the price of the model, matching dated snapshots such as
+// "gpt-4o-2024-08-06" to their base model. Other variants, such as "gpt-4o-audio-preview",
+// are priced differently, so they are not matched, see isSnapshot. The caller must hold pricingMutex.
+func lookupPrice(model string) (price, bool) {
+	if p, ok := pricing[model]; ok {
+		return p, true
+	}
+
+	for name, p := range pricing {
+		if strings.HasPrefix(model, name+"-") && isSnapshot(model[len(name)+1:]) {
+			return p, true
+		}
+	}
+
+	return price{}, false
+}
+
+// EstimateCost returns an estimate in dollars of the cost of all the requests of the chat,
+// from their cumulative usage and the price of their model, see CumulativeUsage and SetPricing.
+// The built-in prices are the published prices of standard requests, without discounts such as
+// those of cached prompt tokens. It returns an error if a model has no price.
+func (c *Chat) EstimateCost() (float64, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	pricingMutex.RLock()
+	defer pricingMutex.RUnlock()
+
+	cost := 0.0
+	for model, usage := range c.modelUsage {
+		p, ok := lookupPrice(model)
+		if !ok {
+			return 0, fmt.Errorf("no price for model %s, set one with SetPricing", model)
+		}
+		cost += float64(usage.PromptTokens)/1000*p.prompt + float64(usage.CompletionTokens)/1000*p.completion
+	}
+
+	return cost, nil
+}
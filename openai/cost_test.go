@@ -0,0 +1,55 @@
+// @file cost_test.go
+// @brief Tests of the cost estimation from token usage.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		model string
+		// Cost of 1000 prompt and 1000 completion tokens, or -1 for no price
+		want float64
+	}{
+		{"gpt-4", 0.09},
+		{"gpt-4-0613", 0.09},
+		{"gpt-4o", 0.0125},
+		{"gpt-4o-2024-08-06", 0.0125},
+		{"gpt-4o-2024-05-13", 0.02},
+		{"gpt-4o-mini-2024-07-18", 0.00075},
+		{"gpt-3.5-turbo-0125", 0.002},
+		{"o3-mini-2025-01-31", 0.0055},
+		// Variants priced differently from their base model have no price.
+		{"gpt-4-32k", -1},
+		{"gpt-4-vision-preview", -1},
+		{"gpt-3.5-turbo-instruct", -1},
+		{"gpt-4o-realtime-preview", -1},
+		{"gpt-4o-audio-preview", -1},
+		{"gpt-4o-audio-preview-2024-10-01", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			c := &Chat{}
+			c.addUsage(&ChatResponse{Model: test.model, Usages: Usage{PromptTokens: 1000, CompletionTokens: 1000}})
+
+			cost, err := c.EstimateCost()
+			if test.want < 0 {
+				if err == nil || !strings.Contains(err.Error(), "no price") {
+					t.Errorf("EstimateCost = %v, %v, want the no price error", cost, err)
+				}
+				return
+			}
+			if err != nil || math.Abs(cost-test.want) > 1e-9 {
+				t.Errorf("EstimateCost = %v, %v, want %v", cost, err, test.want)
+			}
+		})
+	}
+}
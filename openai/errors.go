@@ -0,0 +1,135 @@
+// @file errors.go
+// @brief Error responses of OpenAI API. (https://platform.openai.com/docs/guides/error-codes)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrOutOfRange is returned by the setters when a parameter is outside the range the API accepts.
+var ErrOutOfRange = errors.New("parameter out of range")
+
+// ErrMultipleChoices is returned by NewChat when SetN asks for several choices while SetAutoAppend is enabled,
+// as only one choice can be appended to the messages.
+var ErrMultipleChoices = errors.New("several choices requested with auto-append enabled")
+
+// ErrMissingAPIKey is returned by requests of a chat whose key has not been set with SetAuthorizationKey.
+var ErrMissingAPIKey = errors.New("missing api key, set it with SetAuthorizationKey")
+
+// ErrChatClosed is returned by requests of a chat after it is closed with Close.
+var ErrChatClosed = errors.New("chat closed")
+
+// ErrUnknownTool is returned by HandleToolCalls when the model calls tools that have no handler.
+var ErrUnknownTool = errors.New("unknown tool")
+
+// ErrContextLengthExceeded is matched by errors.Is when the API rejects a request because the messages
+// and the maximum number of tokens exceed the context window of the model, for example to trim the history and retry.
+var ErrContextLengthExceeded = errors.New("context length exceeded")
+
+// APIError is the error returned when the API responds with a non-2xx status.
+// Use errors.As to inspect the status code, for example to decide whether to retry.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+	// Message is the human readable error message.
+	Message string `json:"message"`
+	// Type is the type of the error, such as "invalid_request_error".
+	Type string `json:"type"`
+	// Param is the request parameter the error relates to, if any.
+	Param string `json:"param"`
+	// Code is the error code, such as "invalid_api_key".
+	Code string `json:"code"`
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("api error %d: %s (%s)", e.StatusCode, e.Message, e.Code)
+	}
+
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether the error matches the target, so that errors.Is matches ErrContextLengthExceeded.
+func (e *APIError) Is(target error) bool {
+	return target == ErrContextLengthExceeded && e.Code == "context_length_exceeded"
+}
+
+// newAPIError parses the error envelope of a non-2xx response.
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := parseErrorEnvelope(statusCode, body)
+	if apiErr == nil {
+		// The body is not an error envelope, such as the HTML error page of a proxy.
+		message := http.StatusText(statusCode)
+		if text := snippet(body); text != "" {
+			message += ": " + text
+		}
+		return &APIError{StatusCode: statusCode, Message: message}
+	}
+
+	return apiErr
+}
+
+// parseErrorEnvelope returns the error of the body, or nil if the body is not an error envelope.
+func parseErrorEnvelope(statusCode int, body []byte) *APIError {
+	envelope := struct {
+		Error *APIError `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+		return nil
+	}
+
+	envelope.Error.StatusCode = statusCode
+	return envelope.Error
+}
+
+// isSuccess reports whether the status code is 2xx.
+func isSuccess(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
+// checkRange returns ErrOutOfRange if the value of the parameter is not between min and max.
+func checkRange(name string, value, min, max float64) error {
+	if !(value >= min && value <= max) {
+		return fmt.Errorf("%w: %s must be between %v and %v, got %v", ErrOutOfRange, name, min, max, value)
+	}
+
+	return nil
+}
+
+// maxSnippet is the maximum length of the body quoted in errors.
+const maxSnippet = 200
+
+// snippet returns the start of the body, to quote it in errors.
+func snippet(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if len(text) <= maxSnippet {
+		return text
+	}
+
+	end := maxSnippet
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end] + "..."
+}
+
+// decodeError returns the error of a response body that could not be decoded,
+// with the status code and the start of the body.
+func decodeError(statusCode int, body []byte, err error) error {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return fmt.Errorf("empty response body with status %d", statusCode)
+	}
+
+	return fmt.Errorf("invalid response body with status %d: %w: %q", statusCode, err, snippet(body))
+}
@@ -0,0 +1,274 @@
+// @file history.go
+// @brief Editing of the message history of a chat.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// RemoveLastMessage is used to remove the last message of the chat.
+// It does nothing if the chat has no messages.
+func (c *Chat) RemoveLastMessage() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.messages) == 0 {
+		return
+	}
+	c.removeMessages(len(c.messages)-1, len(c.messages))
+}
+
+// RemoveLastExchange is used to undo the last exchange: the last user message and every
+// message after it, such as the assistant reply and any tool results.
+// It does nothing if the chat has no user message.
+func (c *Chat) RemoveLastExchange() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for index := len(c.messages) - 1; index >= 0; index-- {
+		if c.messages[index].Role == RoleUser {
+			c.removeMessages(index, len(c.messages))
+			return
+		}
+	}
+}
+
+// SetMessages is used to replace all messages of the chat, for example to restore a conversation
+// saved with GetMessages. The messages are copied, so later changes to the slice do not affect the chat.
+func (c *Chat) SetMessages(messages []Message) {
+	list := copyMessages(messages)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.messages, c.replyIndex = list, 0
+}
+
+// DeleteMessageAt is used to remove the message at the index, as returned by GetMessages.
+// A negative index counts from the end, so -1 is the last message.
+// It returns an error if the index is out of range.
+func (c *Chat) DeleteMessageAt(index int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	position := index
+	if position < 0 {
+		position += len(c.messages)
+	}
+	if position < 0 || position >= len(c.messages) {
+		return fmt.Errorf("message index %d out of range, the chat has %d messages", index, len(c.messages))
+	}
+
+	c.removeMessages(position, position+1)
+	return nil
+}
+
+// InsertMessageAt is used to insert a message before the message at the index, shifting later messages down.
+// An index equal to the number of messages appends the message. A negative index counts from the end,
+// so -1 inserts before the last message, for example to add retrieved context before the latest question.
+// It returns an error if the index is out of range.
+func (c *Chat) InsertMessageAt(index int, role Role, content string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	position := index
+	if position < 0 {
+		position += len(c.messages)
+	}
+	if position < 0 || position > len(c.messages) {
+		return fmt.Errorf("message index %d out of range, the chat has %d messages", index, len(c.messages))
+	}
+
+	c.insertMessage(position, Message{Role: role, Content: content})
+	return nil
+}
+
+// removeMessages removes the messages from start to end, keeping track of the appended choice
+// of the last response, see SelectChoice. The caller must hold the mutex.
+func (c *Chat) removeMessages(start, end int) {
+	c.messages = append(c.messages[:start], c.messages[end:]...)
+	switch {
+	case c.replyIndex > end:
+		c.replyIndex -= end - start
+	case c.replyIndex > start:
+		c.replyIndex = 0
+	}
+}
+
+// insertMessage inserts the message before the message at the position, keeping track of the appended choice
+// of the last response, see SelectChoice. The caller must hold the mutex.
+func (c *Chat) insertMessage(position int, message Message) {
+	c.messages = append(c.messages, Message{})
+	copy(c.messages[position+1:], c.messages[position:])
+	c.messages[position] = message
+	if c.replyIndex > position {
+		c.replyIndex++
+	}
+}
+
+// commitReply records the message at the position as the appended choice of the last response.
+// The caller must hold the mutex.
+func (c *Chat) commitReply(position int) {
+	c.replyIndex, c.replyKey = position+1, newMessageKey(c.messages[position])
+}
+
+// committedReply returns the position of the appended choice of the last response, or -1 if none was
+// appended or the message at its position has been changed since. The caller must hold the mutex.
+func (c *Chat) committedReply() int {
+	position := c.replyIndex - 1
+	if position < 0 || position >= len(c.messages) || newMessageKey(c.messages[position]) != c.replyKey {
+		return -1
+	}
+
+	return position
+}
+
+// SetMaxHistoryTokens is used to keep the messages under a token budget in long conversations.
+// Before each request, the oldest exchanges are removed from the messages until their estimate
+// fits the budget: the oldest message that is not an instruction, and the replies and tool results after it.
+// Instructions, which are system and developer messages, and the last user message are never removed,
+// so the budget may still be exceeded.
+// This is best-effort, as it relies on EstimateTokens; if the estimate fails, nothing is removed.
+// A maxTokens of 0 disables trimming.
+func (c *Chat) SetMaxHistoryTokens(maxTokens int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxHistoryTokens = maxTokens
+}
+
+// trimHistory removes the oldest exchanges until the messages fit the token budget.
+func (c *Chat) trimHistory() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.maxHistoryTokens <= 0 {
+		return
+	}
+
+	lastUser := -1
+	for index := range c.messages {
+		if c.messages[index].Role == RoleUser {
+			lastUser = index
+		}
+	}
+
+	counts, cache, err := countMessages(context.Background(), encodingForModel(c.model()), c.messages, c.tokenCounts)
+	if err != nil {
+		return
+	}
+	c.tokenCounts = cache
+
+	tokens := sumTokens(counts)
+	for tokens > c.maxHistoryTokens {
+		// Remove the oldest message that is not an instruction, and the messages
+		// up to the next user message, so that no reply or tool result is left without its request.
+		start := 0
+		for start < lastUser && isInstruction(c.messages[start]) {
+			start++
+		}
+		if start >= lastUser {
+			break
+		}
+		end := start + 1
+		for end < lastUser && c.messages[end].Role != RoleUser && !isInstruction(c.messages[end]) {
+			end++
+		}
+
+		c.removeMessages(start, end)
+		for _, count := range counts[start:end] {
+			tokens -= count
+		}
+		counts = append(counts[:start], counts[end:]...)
+		lastUser -= end - start
+	}
+}
+
+// isInstruction reports whether the message is a system or developer message, which trimming keeps.
+func isInstruction(message Message) bool {
+	return message.Role == RoleSystem || message.Role == RoleDeveloper
+}
+
+// SetMessageFilter is used to transform each message right before it is sent, for example to redact
+// personal data or to shorten long messages. The filter receives a copy of each message and returns the
+// message to send instead. A message with an empty role, such as the zero Message{}, leaves it out.
+// The messages of the chat are not changed.
+// A nil filter removes it.
+func (c *Chat) SetMessageFilter(filter func(message Message) Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.messageFilter = filter
+}
+
+// filterMessages returns the messages transformed by the filter, without the dropped ones.
+func filterMessages(val interface{}, filter func(message Message) Message) interface{} {
+	messages, ok := val.([]Message)
+	if !ok {
+		return val
+	}
+
+	filtered := make([]Message, 0, len(messages))
+	for _, message := range copyMessages(messages) {
+		if message = filter(message); message.Role != "" {
+			filtered = append(filtered, message)
+		}
+	}
+
+	return filtered
+}
+
+// MarshalHistory returns the messages of the chat encoded as a JSON array,
+// in the same format as the messages of a request.
+func (c *Chat) MarshalHistory() ([]byte, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.messages == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(c.messages)
+}
+
+// LoadHistory replaces the messages of the chat with the JSON array returned by MarshalHistory.
+// The messages are left unchanged if the data cannot be decoded.
+func (c *Chat) LoadHistory(data []byte) error {
+	var messages []Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.messages, c.replyIndex = messages, 0
+	return nil
+}
+
+// copyMessages returns a deep copy of the messages.
+func copyMessages(messages []Message) []Message {
+	if messages == nil {
+		return nil
+	}
+
+	list := make([]Message, len(messages))
+	copy(list, messages)
+	for index := range list {
+		if list[index].Parts != nil {
+			list[index].Parts = append([]ContentPart(nil), list[index].Parts...)
+		}
+		if list[index].ToolCalls != nil {
+			list[index].ToolCalls = append([]ToolCall(nil), list[index].ToolCalls...)
+		}
+	}
+
+	return list
+}
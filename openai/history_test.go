@@ -0,0 +1,69 @@
+// @file history_test.go
+// @brief Tests of the management of the messages of a chat.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTrimHistoryKeepsInstructions(t *testing.T) {
+	c := &Chat{}
+	c.AddMessageAsSystem("You are a helpful assistant.")
+	for index := 0; index < 5; index++ {
+		c.AddMessageAsUser(fmt.Sprintf("Question %d?", index))
+		c.AddMessageAsAssistant(fmt.Sprintf("Answer %d.", index))
+		if index == 2 {
+			c.AddMessageAsDeveloper("Answer in French from now on.")
+		}
+	}
+	c.AddMessageAsUser("Last question?")
+	c.SetMaxHistoryTokens(1)
+
+	c.trimHistory()
+	messages := c.GetMessages()
+	want := []Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "developer", Content: "Answer in French from now on."},
+		{Role: "user", Content: "Last question?"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("messages = %+v, want %+v", messages, want)
+	}
+	for index := range want {
+		if messages[index].Role != want[index].Role || messages[index].Content != want[index].Content {
+			t.Errorf("message %d = %+v, want %+v", index, messages[index], want[index])
+		}
+	}
+}
+
+func TestMessageFilter(t *testing.T) {
+	c := &Chat{}
+	c.AddMessageAsSystem("Internal notes.")
+	c.AddMessageAsUser("My email is jane@example.com.")
+	c.SetMessageFilter(func(message Message) Message {
+		if message.Role == RoleSystem {
+			return Message{}
+		}
+		message.Content = strings.ReplaceAll(message.Content, "jane@example.com", "[email]")
+		return message
+	})
+
+	data, err := c.requestBody(nil)
+	if err != nil {
+		t.Fatalf("requestBody: %v", err)
+	}
+	messages := data["messages"].([]Message)
+	if len(messages) != 1 || messages[0].Content != "My email is [email]." {
+		t.Errorf("sent messages = %+v, want the system message dropped and the email redacted", messages)
+	}
+	if kept := c.GetMessages(); len(kept) != 2 || kept[1].Content != "My email is jane@example.com." {
+		t.Errorf("messages = %+v, want the messages of the chat unchanged", kept)
+	}
+}
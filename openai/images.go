@@ -0,0 +1,127 @@
+// @file images.go
+// @brief Images API implementation for OpenAI API. (https://platform.openai.com/docs/api-reference/images)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ImageData is the image object is used to represent a generated image.
+type ImageData struct {
+	// URL is the URL of the image, if the response format is "url". It expires after an hour.
+	URL string `json:"url"`
+	// B64JSON is the base64 encoded image, if the response format is "b64_json".
+	B64JSON string `json:"b64_json"`
+	// RevisedPrompt is the prompt the image was generated from, if the model revised it.
+	RevisedPrompt string `json:"revised_prompt"`
+}
+
+// ImageResponse is the response of an image generation request.
+type ImageResponse struct {
+	// Created is the timestamp of when the images were created.
+	Created int `json:"created"`
+	// Data is the list of generated images.
+	Data []ImageData `json:"data"`
+}
+
+// ImageOption configures an image generation request, see Images.Generate.
+type ImageOption func(params map[string]interface{}) error
+
+// WithImageModel model string Optional Defaults to dall-e-2;
+// The model to use for image generation, such as "dall-e-3".
+func WithImageModel(model string) ImageOption {
+	return func(params map[string]interface{}) error {
+		params["model"] = model
+		return nil
+	}
+}
+
+// WithImageN n integer Optional Defaults to 1;
+// The number of images to generate. dall-e-3 only supports 1.
+// It returns ErrOutOfRange if n is not between 1 and 10.
+func WithImageN(n int) ImageOption {
+	return func(params map[string]interface{}) error {
+		if n < 1 || n > 10 {
+			return fmt.Errorf("%w: n must be between 1 and 10, got %d", ErrOutOfRange, n)
+		}
+		params["n"] = n
+		return nil
+	}
+}
+
+// WithImageSize size string Optional Defaults to 1024x1024;
+// The size of the generated images, such as "256x256", "512x512", "1024x1024", "1792x1024" or "1024x1792".
+func WithImageSize(size string) ImageOption {
+	return func(params map[string]interface{}) error {
+		params["size"] = size
+		return nil
+	}
+}
+
+// WithImageQuality quality string Optional Defaults to standard;
+// The quality of the generated images, "standard" or "hd". Only supported by dall-e-3.
+func WithImageQuality(quality string) ImageOption {
+	return func(params map[string]interface{}) error {
+		params["quality"] = quality
+		return nil
+	}
+}
+
+// WithImageStyle style string Optional Defaults to vivid;
+// The style of the generated images, "vivid" or "natural". Only supported by dall-e-3.
+func WithImageStyle(style string) ImageOption {
+	return func(params map[string]interface{}) error {
+		params["style"] = style
+		return nil
+	}
+}
+
+// WithImageResponseFormat response_format string Optional Defaults to url;
+// The format in which the images are returned, "url" or "b64_json".
+func WithImageResponseFormat(format string) ImageOption {
+	return func(params map[string]interface{}) error {
+		params["response_format"] = format
+		return nil
+	}
+}
+
+// Images generates images with the DALL·E models.
+type Images struct {
+	// Chat the key, base URL and other request settings are taken from
+	chat *Chat
+}
+
+// NewImages returns an images client that sends requests with the key, base URL, client,
+// retry policy and other request settings of the chat.
+func (c *Chat) NewImages() *Images {
+	return &Images{chat: c}
+}
+
+// Generate creates images from the prompt. The options configure the request,
+// such as WithImageModel, WithImageSize and WithImageN.
+func (i *Images) Generate(ctx context.Context, prompt string, opts ...ImageOption) (*ImageResponse, error) {
+	params := map[string]interface{}{"prompt": prompt}
+	for _, opt := range opts {
+		if err := opt(params); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &ImageResponse{}
+	if err := i.chat.postJSON(ctx, "/v1/images/generations", params, res); err != nil {
+		return nil, err
+	}
+
+	if res.Data == nil {
+		return nil, errors.New("no response")
+	}
+
+	return res, nil
+}
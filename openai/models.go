@@ -0,0 +1,255 @@
+// @file models.go
+// @brief Model capabilities for OpenAI Chat API. (https://platform.openai.com/docs/models)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Model features.
+const (
+	// FeatureTools is function calling with tools.
+	FeatureTools = "tools"
+	// FeatureVision is image input in message content.
+	FeatureVision = "vision"
+	// FeatureJSONMode is response_format of type json_object.
+	FeatureJSONMode = "json_object"
+	// FeatureJSONSchema is response_format of type json_schema.
+	FeatureJSONSchema = "json_schema"
+)
+
+// capabilities is the feature table of known models.
+// Dated snapshots without their own entry use the entry of their base model, see lookupModel.
+var capabilities = map[string][]string{
+	"gpt-3.5-turbo":             {FeatureTools, FeatureJSONMode},
+	"gpt-3.5-turbo-0301":        {},
+	"gpt-3.5-turbo-0613":        {FeatureTools},
+	"gpt-3.5-turbo-16k":         {FeatureTools},
+	"gpt-3.5-turbo-instruct":    {},
+	"gpt-4":                     {FeatureTools},
+	"gpt-4-0314":                {},
+	"gpt-4-32k":                 {},
+	"gpt-4-vision-preview":      {FeatureVision},
+	"gpt-4-1106-vision-preview": {FeatureVision},
+	"gpt-4-1106-preview":        {FeatureTools, FeatureJSONMode},
+	"gpt-4-0125-preview":        {FeatureTools, FeatureJSONMode},
+	"gpt-4-turbo-preview":       {FeatureTools, FeatureJSONMode},
+	"gpt-4-turbo":               {FeatureTools, FeatureVision, FeatureJSONMode},
+	"gpt-4o":                    {FeatureTools, FeatureVision, FeatureJSONMode, FeatureJSONSchema},
+	"gpt-4o-mini":               {FeatureTools, FeatureVision, FeatureJSONMode, FeatureJSONSchema},
+	"o1":                        {FeatureTools, FeatureVision, FeatureJSONMode, FeatureJSONSchema},
+	"o1-mini":                   {},
+	"o1-preview":                {},
+	"o3-mini":                   {FeatureTools, FeatureJSONMode, FeatureJSONSchema},
+}
+
+// lookupModel returns the features of the model, matching dated snapshots such as
+// "gpt-4o-2024-08-06" or "gpt-4-0613" to their base model. The boolean is false for unknown models.
+// Other suffixes, such as in "gpt-4o-audio-preview", name variants that may differ in capabilities,
+// so they are not matched to the base model.
+func lookupModel(model string) ([]string, bool) {
+	if features, ok := capabilities[model]; ok {
+		return features, true
+	}
+
+	for name, features := range capabilities {
+		if strings.HasPrefix(model, name+"-") && isSnapshot(model[len(name)+1:]) {
+			return features, true
+		}
+	}
+
+	return nil, false
+}
+
+// isSnapshot reports whether the suffix of a model name is the date of a snapshot, such as "2024-08-06" or "0613".
+func isSnapshot(suffix string) bool {
+	digits := func(text string) bool {
+		for _, r := range text {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(suffix) == len("0613") {
+		return digits(suffix)
+	}
+	if len(suffix) == len("2024-08-06") && suffix[4] == '-' && suffix[7] == '-' {
+		return digits(suffix[:4]) && digits(suffix[5:7]) && digits(suffix[8:])
+	}
+
+	return false
+}
+
+// ModelSupports reports whether the model supports the feature.
+// Models missing from the table are assumed to support every feature,
+// so that newer models are never rejected.
+func ModelSupports(model, feature string) bool {
+	features, ok := lookupModel(model)
+	if !ok {
+		return true
+	}
+
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkModel returns an error naming the first feature of the request that the model does not support.
+func checkModel(model string, features []string) error {
+	for _, feature := range features {
+		if !ModelSupports(model, feature) {
+			return fmt.Errorf("model %s does not support %s", model, feature)
+		}
+	}
+
+	return nil
+}
+
+// requestFeatures returns the features used by the request data.
+func requestFeatures(data map[string]interface{}) []string {
+	var features []string
+
+	if _, ok := data["tools"]; ok {
+		features = append(features, FeatureTools)
+	}
+	if format, ok := data["response_format"].(ResponseFormat); ok {
+		switch format.Type {
+		case "json_object":
+			features = append(features, FeatureJSONMode)
+		case "json_schema":
+			features = append(features, FeatureJSONSchema)
+		}
+	}
+	if hasImages(data["messages"]) {
+		features = append(features, FeatureVision)
+	}
+
+	return features
+}
+
+// hasImages reports whether any of the messages has an image part.
+func hasImages(val interface{}) bool {
+	messages, _ := val.([]Message)
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if part.Type == PartImageURL {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// reasoningModels are the prefixes of the reasoning models, which reject some sampling parameters.
+var reasoningModels = []string{"o1", "o3", "o4"}
+
+// reasoningUnsupported are the parameters rejected by reasoning models.
+var reasoningUnsupported = []string{
+	"temperature", "top_p", "presence_penalty", "frequency_penalty", "logprobs", "top_logprobs", "logit_bias",
+}
+
+// isReasoningModel reports whether the model is a reasoning model, such as "o1-mini" or "o3-mini-2025-01-31".
+func isReasoningModel(model string) bool {
+	for _, prefix := range reasoningModels {
+		if model == prefix || strings.HasPrefix(model, prefix+"-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// instructionlessModels are the reasoning models that reject both system and developer messages.
+var instructionlessModels = []string{"o1-mini", "o1-preview"}
+
+// acceptsInstructions reports whether the reasoning model takes developer messages, unlike "o1-mini",
+// "o1-preview" and their snapshots.
+func acceptsInstructions(model string) bool {
+	for _, name := range instructionlessModels {
+		if model == name || strings.HasPrefix(model, name+"-") && isSnapshot(model[len(name)+1:]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// adaptReasoningParams removes the parameters rejected by the reasoning model from the request data,
+// and sends max_tokens as max_completion_tokens unless that is set too. System messages are sent as
+// developer messages, or merged into the first user message for models without instructions, see mergeInstructions.
+func adaptReasoningParams(model string, data map[string]interface{}) {
+	if messages, ok := data["messages"].([]Message); ok {
+		if acceptsInstructions(model) {
+			adapted := make([]Message, len(messages))
+			copy(adapted, messages)
+			for index := range adapted {
+				if adapted[index].Role == RoleSystem {
+					adapted[index].Role = RoleDeveloper
+				}
+			}
+			data["messages"] = adapted
+		} else {
+			data["messages"] = mergeInstructions(messages)
+		}
+	}
+	for _, key := range reasoningUnsupported {
+		delete(data, key)
+	}
+	if maxTokens, ok := data["max_tokens"]; ok {
+		if _, ok := data["max_completion_tokens"]; !ok {
+			data["max_completion_tokens"] = maxTokens
+		}
+		delete(data, "max_tokens")
+	}
+}
+
+// mergeInstructions returns the messages with the text of the system and developer messages
+// put before the text of the first user message, each followed by a blank line.
+// Without a user message, the instructions are sent as a user message instead.
+func mergeInstructions(messages []Message) []Message {
+	instructions := strings.Builder{}
+	merged := make([]Message, 0, len(messages))
+	first := -1
+	for _, message := range messages {
+		if !isInstruction(message) {
+			merged = append(merged, message)
+			continue
+		}
+		if first < 0 {
+			first = len(merged)
+		}
+		instructions.WriteString(message.text())
+		instructions.WriteString("\n\n")
+	}
+	if first < 0 {
+		return merged
+	}
+
+	for index := range merged {
+		if merged[index].Role != RoleUser {
+			continue
+		}
+		if len(merged[index].Parts) == 0 {
+			merged[index].Content = instructions.String() + merged[index].Content
+		} else {
+			merged[index].Parts = append([]ContentPart{TextPart(instructions.String())}, merged[index].Parts...)
+		}
+		return merged
+	}
+
+	user := Message{Role: RoleUser, Content: strings.TrimSuffix(instructions.String(), "\n\n")}
+	return append(merged[:first], append([]Message{user}, merged[first:]...)...)
+}
@@ -0,0 +1,124 @@
+// @file models_test.go
+// @brief Tests of the model capabilities.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestModelSupports(t *testing.T) {
+	tests := []struct {
+		model   string
+		feature string
+		want    bool
+	}{
+		{"gpt-4-vision-preview", FeatureVision, true},
+		{"gpt-4-1106-vision-preview", FeatureVision, true},
+		{"gpt-4-vision-preview", FeatureTools, false},
+		{"gpt-4", FeatureVision, false},
+		{"gpt-4-0613", FeatureVision, false},
+		{"gpt-4-0314", FeatureTools, false},
+		{"gpt-4-32k-0613", FeatureTools, false},
+		{"gpt-4-turbo-2024-04-09", FeatureVision, true},
+		{"gpt-4o-2024-08-06", FeatureJSONSchema, true},
+		{"gpt-4o-mini-2024-07-18", FeatureVision, true},
+		{"gpt-3.5-turbo-1106", FeatureJSONMode, true},
+		{"gpt-3.5-turbo-16k-0613", FeatureJSONMode, false},
+		{"o1-mini-2024-09-12", FeatureTools, false},
+		{"o3-mini-2025-01-31", FeatureVision, false},
+		// Variants are not matched to their base model, so they are assumed to support everything.
+		{"gpt-4o-audio-preview", FeatureVision, true},
+		{"gpt-4-custom-vision", FeatureVision, true},
+		{"my-local-model", FeatureTools, true},
+	}
+	for _, test := range tests {
+		if got := ModelSupports(test.model, test.feature); got != test.want {
+			t.Errorf("ModelSupports(%q, %q) = %v, want %v", test.model, test.feature, got, test.want)
+		}
+	}
+}
+
+func TestCheckModelVision(t *testing.T) {
+	messages := []Message{{Role: "user", Parts: []ContentPart{TextPart("What is this?"), ImageURLPart("https://example.com/a.png", "")}}}
+	features := requestFeatures(map[string]interface{}{"messages": messages})
+
+	if err := checkModel("gpt-4-vision-preview", features); err != nil {
+		t.Errorf("checkModel(gpt-4-vision-preview) = %v, want nil", err)
+	}
+	if err := checkModel("gpt-4", features); err == nil {
+		t.Error("checkModel(gpt-4) succeeded, want an error for an image")
+	}
+}
+
+func TestIsSnapshot(t *testing.T) {
+	tests := map[string]bool{
+		"0613":       true,
+		"2024-08-06": true,
+		"preview":    false,
+		"16k":        false,
+		"16k-0613":   false,
+		"2024-08":    false,
+		"2024x08x06": false,
+		"":           false,
+	}
+	for suffix, want := range tests {
+		if got := isSnapshot(suffix); got != want {
+			t.Errorf("isSnapshot(%q) = %v, want %v", suffix, got, want)
+		}
+	}
+}
+
+func TestAdaptReasoningParams(t *testing.T) {
+	messages := []Message{
+		{Role: RoleSystem, Content: "Answer briefly."},
+		{Role: RoleUser, Content: "Why is the sky blue?"},
+	}
+	tests := []struct {
+		model    string
+		messages []Message
+		want     []Message
+	}{
+		{"o1", messages, []Message{
+			{Role: RoleDeveloper, Content: "Answer briefly."},
+			{Role: RoleUser, Content: "Why is the sky blue?"},
+		}},
+		{"o3-mini", messages, []Message{
+			{Role: RoleDeveloper, Content: "Answer briefly."},
+			{Role: RoleUser, Content: "Why is the sky blue?"},
+		}},
+		{"o1-mini", messages, []Message{
+			{Role: RoleUser, Content: "Answer briefly.\n\nWhy is the sky blue?"},
+		}},
+		{"o1-preview-2024-09-12", append([]Message{{Role: RoleDeveloper, Content: "Be kind."}}, messages...), []Message{
+			{Role: RoleUser, Content: "Be kind.\n\nAnswer briefly.\n\nWhy is the sky blue?"},
+		}},
+		{"o1-mini", []Message{
+			{Role: RoleSystem, Content: "Answer briefly."},
+			{Role: RoleUser, Parts: []ContentPart{TextPart("What is this?")}},
+		}, []Message{
+			{Role: RoleUser, Parts: []ContentPart{TextPart("Answer briefly.\n\n"), TextPart("What is this?")}},
+		}},
+		{"o1-mini", []Message{{Role: RoleSystem, Content: "Answer briefly."}}, []Message{
+			{Role: RoleUser, Content: "Answer briefly."},
+		}},
+	}
+	for _, test := range tests {
+		data := map[string]interface{}{"messages": test.messages, "temperature": 0.5, "max_tokens": 100}
+		adaptReasoningParams(test.model, data)
+		if got := data["messages"]; fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s messages = %+v, want %+v", test.model, got, test.want)
+		}
+		if _, ok := data["temperature"]; ok || data["max_completion_tokens"] != 100 {
+			t.Errorf("%s params = %v, want temperature removed and max_tokens sent as max_completion_tokens", test.model, data)
+		}
+	}
+	if messages[0].Role != RoleSystem || messages[1].Content != "Why is the sky blue?" {
+		t.Errorf("messages = %+v, want the messages of the chat unchanged", messages)
+	}
+}
@@ -0,0 +1,53 @@
+// @file moderation.go
+// @brief Moderation API implementation for OpenAI API. (https://platform.openai.com/docs/api-reference/moderations)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"errors"
+)
+
+// ModerationResult is the moderation object is used to represent the moderation of an input.
+type ModerationResult struct {
+	// Flagged is whether the input violates the usage policies.
+	Flagged bool `json:"flagged"`
+	// Categories is whether the input is flagged, by category such as "hate" or "violence".
+	Categories map[string]bool `json:"categories"`
+	// CategoryScores is the score of the input between 0 and 1, by category.
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+// ModerationResponse is the moderation response object is used to represent the response of the moderation API.
+type ModerationResponse struct {
+	// ID is the ID of the moderation request.
+	ID string `json:"id"`
+	// Model is the model used to classify the input.
+	Model string `json:"model"`
+	// Results is the list of moderation results, one per input.
+	Results []ModerationResult `json:"results"`
+}
+
+// Moderate is used to check whether the input violates the usage policies, before sending it to the chat.
+// It uses the key and base URL of the chat.
+func (c *Chat) Moderate(input string) (*ModerationResult, error) {
+	return c.ModerateWithContext(context.Background(), input)
+}
+
+// ModerateWithContext is like Moderate, but the request is cancelled when the context is done.
+func (c *Chat) ModerateWithContext(ctx context.Context, input string) (*ModerationResult, error) {
+	res := &ModerationResponse{}
+	if err := c.postJSON(ctx, "/v1/moderations", map[string]interface{}{"input": input}, res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Results) == 0 {
+		return nil, errors.New("no response")
+	}
+
+	return &res.Results[0], nil
+}
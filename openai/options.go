@@ -0,0 +1,130 @@
+// @file options.go
+// @brief Functional options for single requests.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// Option configures a chat, see CompleteOnce.
+type Option func(c *Chat) error
+
+// WithModel sets the model, see Chat.SetModel.
+func WithModel(model string) Option {
+	return func(c *Chat) error {
+		c.SetModel(model)
+		return nil
+	}
+}
+
+// WithTemperature sets the sampling temperature, see Chat.SetTemperature.
+func WithTemperature(temperature float64) Option {
+	return func(c *Chat) error {
+		return c.SetTemperature(temperature)
+	}
+}
+
+// WithTopP sets the nucleus sampling probability mass, see Chat.SetTopP.
+func WithTopP(topP float64) Option {
+	return func(c *Chat) error {
+		return c.SetTopP(topP)
+	}
+}
+
+// WithMaxTokens sets the maximum number of tokens to generate, see Chat.SetMaxTokens.
+func WithMaxTokens(maxTokens int) Option {
+	return func(c *Chat) error {
+		return c.SetMaxTokens(maxTokens)
+	}
+}
+
+// WithN sets the number of choices to generate, see Chat.SetN.
+func WithN(n int) Option {
+	return func(c *Chat) error {
+		return c.SetN(n)
+	}
+}
+
+// WithSystemPrompt sets the system message that every conversation starts with, see Chat.SetSystemPrompt.
+func WithSystemPrompt(prompt string) Option {
+	return func(c *Chat) error {
+		c.SetSystemPrompt(prompt)
+		return nil
+	}
+}
+
+// WithBaseURL sets the base URL of the API, see Chat.SetBaseURL.
+func WithBaseURL(base string) Option {
+	return func(c *Chat) error {
+		c.SetBaseURL(base)
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client, see Chat.SetHTTPClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Chat) error {
+		c.SetHTTPClient(client)
+		return nil
+	}
+}
+
+// CompleteOnce sends the messages in a single request, without creating a Chat to keep the history.
+// The options configure the request, such as WithModel and WithTemperature.
+func CompleteOnce(ctx context.Context, key string, messages []Message, opts ...Option) (*ChatResponse, error) {
+	c := &Chat{messages: copyMessages(messages)}
+	c.SetAuthorizationKey(key)
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.newChat(ctx)
+}
+
+// NewChatWithOptions sends the chat request like NewChatWithContext, with the options applied to this
+// request only, such as WithTemperature(0). The parameters of the chat are left unchanged,
+// so they act as defaults that each call may override.
+func (c *Chat) NewChatWithOptions(ctx context.Context, opts ...Option) (*ChatResponse, error) {
+	// The request is sent by a clone, which is not closed even if the chat is.
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrChatClosed
+	}
+	c.trimHistory()
+
+	request := c.Clone()
+	for _, opt := range opts {
+		if err := opt(request); err != nil {
+			return nil, err
+		}
+	}
+	if err := request.checkChoices(); err != nil {
+		return nil, err
+	}
+
+	res, err := request.send(ctx, nil)
+	// The rate limit state is also kept after an error, such as a 429 response.
+	if info, ok := request.lastRateLimit.Load().(RateLimitInfo); ok {
+		c.lastRateLimit.Store(info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.addUsage(res)
+	c.storeResponse(res)
+
+	if c.autoAppend() {
+		c.CommitResponse(res)
+	}
+
+	return res, nil
+}
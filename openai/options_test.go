@@ -0,0 +1,76 @@
+// @file options_test.go
+// @brief Tests of the functional options for single requests.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestNewChatWithOptions(t *testing.T) {
+	requests := 0
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body := decodeRequest(t, r)
+		if requests == 1 && body["temperature"] != 0.0 {
+			t.Errorf("temperature = %v, want 0 from the option", body["temperature"])
+		}
+		if requests == 2 && body["temperature"] != 1.0 {
+			t.Errorf("temperature = %v, want 1 from the chat", body["temperature"])
+		}
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.SetTemperature(1)
+	c.AddMessageAsUser("Hi")
+
+	if _, err := c.NewChatWithOptions(context.Background(), WithTemperature(0)); err != nil {
+		t.Fatalf("NewChatWithOptions: %v", err)
+	}
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if usage := c.CumulativeUsage(); usage.TotalTokens != 30 {
+		t.Errorf("total tokens = %d, want the usage of both requests", usage.TotalTokens)
+	}
+}
+
+func TestNewChatWithOptionsClosed(t *testing.T) {
+	requests := 0
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.AddMessageAsUser("Hi")
+	c.Close()
+
+	if _, err := c.NewChatWithOptions(context.Background(), WithTemperature(0)); err != ErrChatClosed {
+		t.Errorf("NewChatWithOptions after Close = %v, want ErrChatClosed", err)
+	}
+	if requests != 0 {
+		t.Errorf("%d requests sent after Close, want 0", requests)
+	}
+}
+
+func TestNewChatWithOptionsRateLimit(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Remaining-Requests", "0")
+		w.Header().Set("X-Ratelimit-Reset-Requests", "20s")
+		http.Error(w, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`,
+			http.StatusTooManyRequests)
+	})
+	c.AddMessageAsUser("Hi")
+
+	if _, err := c.NewChatWithOptions(context.Background(), WithTemperature(0)); err == nil {
+		t.Fatal("NewChatWithOptions succeeded, want the rate limit error")
+	}
+	if info := c.LastRateLimit(); info.RemainingRequests != 0 || info.ResetRequests.Seconds() != 20 {
+		t.Errorf("rate limit = %+v, want the state of the response", info)
+	}
+}
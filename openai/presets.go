@@ -0,0 +1,215 @@
+// @file presets.go
+// @brief Presets of request parameters for model families and tasks.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Built-in presets.
+const (
+	// PresetReasoning removes the sampling parameters rejected by reasoning models such as o1.
+	PresetReasoning = "reasoning"
+	// PresetPrecise sets a low temperature, for focused and repeatable answers such as extraction.
+	PresetPrecise = "precise"
+	// PresetCreative sets a high temperature, for varied answers such as brainstorming.
+	PresetCreative = "creative"
+)
+
+// Preset is a set of request parameters applied together, see ApplyPreset.
+type Preset struct {
+	// Params is the request parameters to set, by their API name, such as "temperature".
+	Params map[string]interface{}
+	// Clear is the request parameters to remove, by their API name.
+	Clear []string
+}
+
+var (
+	// presets is the preset registry, by name.
+	presets = map[string]Preset{
+		PresetReasoning: {Clear: reasoningUnsupported},
+		PresetPrecise:   {Params: map[string]interface{}{"temperature": 0.2}, Clear: []string{"top_p"}},
+		PresetCreative:  {Params: map[string]interface{}{"temperature": 1.2}, Clear: []string{"top_p"}},
+	}
+	// presetsMutex guards presets.
+	presetsMutex sync.RWMutex
+)
+
+// RegisterPreset is used to add a preset, or replace the one with the same name,
+// so that it can be applied to any chat with ApplyPreset.
+// The params are checked by the setter of the same name, such as SetN for "n", and converted to the type
+// it takes, so that a preset decoded from JSON, whose numbers are float64, can be registered.
+// Params without a setter are sent as they are. It returns an error if a param has the wrong type
+// or is out of range, and the preset is not registered.
+func RegisterPreset(name string, preset Preset) error {
+	params, err := normalizeParams(preset.Params)
+	if err != nil {
+		return fmt.Errorf("preset %q: %w", name, err)
+	}
+	clear := make([]string, len(preset.Clear))
+	copy(clear, preset.Clear)
+
+	presetsMutex.Lock()
+	defer presetsMutex.Unlock()
+
+	presets[name] = Preset{Params: params, Clear: clear}
+	return nil
+}
+
+// normalizeParams returns the params as stored by their setters, see RegisterPreset.
+func normalizeParams(params map[string]interface{}) (map[string]interface{}, error) {
+	c := &Chat{}
+	for key, value := range params {
+		set, ok := paramSetters[key]
+		if !ok {
+			c.data.Store(key, value)
+			continue
+		}
+		if err := set(c, value); err != nil {
+			return nil, fmt.Errorf("param %s: %w", key, err)
+		}
+	}
+
+	normalized := make(map[string]interface{}, len(params))
+	c.data.Range(func(key, value interface{}) bool {
+		normalized[key.(string)] = value
+		return true
+	})
+	return normalized, nil
+}
+
+// paramSetters calls the setter of each request parameter, by its API name, with the value converted to its type.
+var paramSetters = map[string]func(c *Chat, value interface{}) error{
+	"model": func(c *Chat, value interface{}) error {
+		model, ok := value.(string)
+		if !ok {
+			return typeError("string", value)
+		}
+		c.SetModel(model)
+		return nil
+	},
+	"temperature":           floatSetter((*Chat).SetTemperature),
+	"top_p":                 floatSetter((*Chat).SetTopP),
+	"presence_penalty":      floatSetter((*Chat).SetPresencePenalty),
+	"frequency_penalty":     floatSetter((*Chat).SetFrequencyPenalty),
+	"n":                     intSetter((*Chat).SetN),
+	"max_tokens":            intSetter((*Chat).SetMaxTokens),
+	"max_completion_tokens": intSetter((*Chat).SetMaxCompletionTokens),
+	"top_logprobs":          intSetter((*Chat).SetTopLogprobs),
+	"seed": intSetter(func(c *Chat, seed int) error {
+		c.SetSeed(seed)
+		return nil
+	}),
+	"logit_bias": func(c *Chat, value interface{}) error {
+		logitBias, ok := value.(map[string]int)
+		if !ok {
+			values, ok := value.(map[string]interface{})
+			if !ok {
+				return typeError("map[string]int", value)
+			}
+			logitBias = make(map[string]int, len(values))
+			for token, bias := range values {
+				n, err := toInt(bias)
+				if err != nil {
+					return err
+				}
+				logitBias[token] = n
+			}
+		}
+		return c.SetLogitBias(logitBias)
+	},
+	"stop": func(c *Chat, value interface{}) error {
+		switch stop := value.(type) {
+		case string:
+			c.SetStopStr(stop)
+			return nil
+		case []string:
+			return c.SetStopArr(stop)
+		case []interface{}:
+			list := make([]string, len(stop))
+			for index := range stop {
+				text, ok := stop[index].(string)
+				if !ok {
+					return typeError("string", stop[index])
+				}
+				list[index] = text
+			}
+			return c.SetStopArr(list)
+		}
+		return typeError("string or []string", value)
+	},
+}
+
+// floatSetter returns a param setter calling the setter with the value converted to float64.
+func floatSetter(set func(c *Chat, value float64) error) func(c *Chat, value interface{}) error {
+	return func(c *Chat, value interface{}) error {
+		switch number := value.(type) {
+		case float64:
+			return set(c, number)
+		case float32:
+			return set(c, float64(number))
+		case int:
+			return set(c, float64(number))
+		}
+		return typeError("number", value)
+	}
+}
+
+// intSetter returns a param setter calling the setter with the value converted to int.
+func intSetter(set func(c *Chat, value int) error) func(c *Chat, value interface{}) error {
+	return func(c *Chat, value interface{}) error {
+		n, err := toInt(value)
+		if err != nil {
+			return err
+		}
+		return set(c, n)
+	}
+}
+
+// toInt converts the value to int, accepting the float64 numbers decoded from JSON if they are whole.
+func toInt(value interface{}) (int, error) {
+	switch number := value.(type) {
+	case int:
+		return number, nil
+	case int64:
+		return int(number), nil
+	case float64:
+		if number == float64(int(number)) {
+			return int(number), nil
+		}
+	}
+	return 0, typeError("integer", value)
+}
+
+// typeError returns the error of a param value of the wrong type.
+func typeError(want string, value interface{}) error {
+	return fmt.Errorf("want %s, got %T %v", want, value, value)
+}
+
+// ApplyPreset is used to apply the preset with the name, such as PresetReasoning: its parameters
+// are removed or set, replacing those set before. Other parameters and the messages are kept.
+// It returns an error if no preset has the name.
+func (c *Chat) ApplyPreset(name string) error {
+	presetsMutex.RLock()
+	preset, ok := presets[name]
+	presetsMutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown preset %q", name)
+	}
+
+	for _, key := range preset.Clear {
+		c.data.Delete(key)
+	}
+	for key, value := range preset.Params {
+		c.data.Store(key, value)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// @file presets_test.go
+// @brief Tests of the presets of request parameters.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestRegisterPresetFromJSON(t *testing.T) {
+	preset := Preset{}
+	err := json.Unmarshal([]byte(`{"Params":{"n":2,"max_tokens":100,"temperature":1,`+
+		`"stop":["END"],"logit_bias":{"50256":-100},"service_tier":"flex"},"Clear":["top_p"]}`), &preset)
+	if err != nil {
+		t.Fatalf("decode preset: %v", err)
+	}
+	if err := RegisterPreset("test-json", preset); err != nil {
+		t.Fatalf("RegisterPreset: %v", err)
+	}
+
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		body := decodeRequest(t, r)
+		if body["n"] != 2.0 || body["max_tokens"] != 100.0 || body["service_tier"] != "flex" {
+			t.Errorf("request = %v, want the params of the preset", body)
+		}
+		io.WriteString(w, completionJSON("A", "B"))
+	})
+	c.SetAutoAppend(false)
+	if err := c.ApplyPreset("test-json"); err != nil {
+		t.Fatalf("ApplyPreset: %v", err)
+	}
+	c.AddMessageAsUser("Hi")
+
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	c.SetAutoAppend(true)
+	if _, err := c.NewChat(); !errors.Is(err, ErrMultipleChoices) {
+		t.Errorf("NewChat with n from the preset = %v, want ErrMultipleChoices", err)
+	}
+}
+
+func TestRegisterPresetInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+	}{
+		{"fractional n", map[string]interface{}{"n": 1.5}},
+		{"string temperature", map[string]interface{}{"temperature": "hot"}},
+		{"temperature out of range", map[string]interface{}{"temperature": 3.0}},
+		{"model not a string", map[string]interface{}{"model": 4}},
+		{"stop not strings", map[string]interface{}{"stop": []interface{}{1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := RegisterPreset("test-invalid", Preset{Params: test.params}); err == nil {
+				t.Error("RegisterPreset succeeded, want an error")
+			}
+		})
+	}
+
+	c := &Chat{}
+	if err := c.ApplyPreset("test-invalid"); err == nil {
+		t.Error("an invalid preset was registered")
+	}
+}
+
+func TestCheckedParamTypes(t *testing.T) {
+	c := &Chat{}
+	c.data.Store("model", 4)
+	c.data.Store("n", "two")
+
+	if model := c.model(); model != currentDefaultModel() {
+		t.Errorf("model = %q, want the default model for a model that is not a string", model)
+	}
+	if err := c.checkChoices(); err != nil {
+		t.Errorf("checkChoices = %v, want nil for an n that is not an integer", err)
+	}
+}
@@ -0,0 +1,72 @@
+// @file ratelimit.go
+// @brief Rate limit headers of OpenAI API. (https://platform.openai.com/docs/guides/rate-limits)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitInfo is the rate limit state reported in the headers of a response.
+type RateLimitInfo struct {
+	// LimitRequests is the maximum number of requests allowed before exhausting the rate limit.
+	LimitRequests int
+	// LimitTokens is the maximum number of tokens allowed before exhausting the rate limit.
+	LimitTokens int
+	// RemainingRequests is the number of requests left before exhausting the rate limit.
+	RemainingRequests int
+	// RemainingTokens is the number of tokens left before exhausting the rate limit.
+	RemainingTokens int
+	// ResetRequests is the time until the request rate limit resets to its initial state.
+	ResetRequests time.Duration
+	// ResetTokens is the time until the token rate limit resets to its initial state.
+	ResetTokens time.Duration
+}
+
+// parseRateLimit returns the rate limit state of the response headers.
+// The boolean is false if the response has no rate limit headers, as with some proxies.
+func parseRateLimit(header http.Header) (RateLimitInfo, bool) {
+	found := false
+	number := func(name string) int {
+		value := header.Get(name)
+		if value == "" {
+			return 0
+		}
+		found = true
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	duration := func(name string) time.Duration {
+		value := header.Get(name)
+		if value == "" {
+			return 0
+		}
+		found = true
+		d, _ := time.ParseDuration(value)
+		return d
+	}
+
+	info := RateLimitInfo{
+		LimitRequests:     number("X-Ratelimit-Limit-Requests"),
+		LimitTokens:       number("X-Ratelimit-Limit-Tokens"),
+		RemainingRequests: number("X-Ratelimit-Remaining-Requests"),
+		RemainingTokens:   number("X-Ratelimit-Remaining-Tokens"),
+		ResetRequests:     duration("X-Ratelimit-Reset-Requests"),
+		ResetTokens:       duration("X-Ratelimit-Reset-Tokens"),
+	}
+
+	return info, found
+}
+
+// LastRateLimit returns the rate limit state reported by the most recent response with rate limit headers,
+// including error responses. It returns a zero RateLimitInfo if no such response has been received.
+func (c *Chat) LastRateLimit() RateLimitInfo {
+	info, _ := c.lastRateLimit.Load().(RateLimitInfo)
+	return info
+}
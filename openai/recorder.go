@@ -0,0 +1,253 @@
+// @file recorder.go
+// @brief Record and replay of HTTP exchanges for deterministic tests.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// exchange is a recorded request/response pair.
+type exchange struct {
+	// Request is the recorded request.
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   string `json:"body"`
+	} `json:"request"`
+	// Response is the recorded response.
+	Response struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header"`
+		Body       string      `json:"body"`
+	} `json:"response"`
+}
+
+// fingerprint reads the request body and returns a hash of the method, URL and body.
+// The body is hashed in a canonical form, see canonicalBody, so that the same request
+// always has the same hash. The body of the request is replaced so it can still be sent.
+func fingerprint(req *http.Request) (string, []byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(req.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(req.URL.String()))
+	hash.Write([]byte{0})
+	hash.Write(canonicalBody(req.Header.Get("Content-Type"), body))
+
+	return hex.EncodeToString(hash.Sum(nil)), body, nil
+}
+
+// canonicalBody returns the body in a form that does not depend on how it was encoded.
+// A multipart body, such as that of Transcribe, has a random boundary and fields in any order,
+// so its parts are sorted by name. A JSON body is encoded again with sorted object keys.
+// Other bodies, and bodies that cannot be parsed, are returned as they are.
+func canonicalBody(contentType string, body []byte) []byte {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return body
+	}
+
+	switch mediaType {
+	case "multipart/form-data":
+		if canonical, err := canonicalMultipart(body, params["boundary"]); err == nil {
+			return canonical
+		}
+	case "application/json":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err == nil {
+			if canonical, err := json.Marshal(value); err == nil {
+				return canonical
+			}
+		}
+	}
+
+	return body
+}
+
+// canonicalMultipart returns the parts of the multipart body sorted by name, each with its file name and content.
+func canonicalMultipart(body []byte, boundary string) ([]byte, error) {
+	type field struct {
+		name, filename string
+		content        []byte
+	}
+
+	var fields []field
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field{name: part.FormName(), filename: part.FileName(), content: content})
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+
+	canonical := &bytes.Buffer{}
+	for _, f := range fields {
+		fmt.Fprintf(canonical, "%s\x00%s\x00%d\x00", f.name, f.filename, len(f.content))
+		canonical.Write(f.content)
+	}
+	return canonical.Bytes(), nil
+}
+
+// recorder sends requests and saves each exchange to a directory.
+type recorder struct {
+	dir  string
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, reqBody, err := fingerprint(req)
+	if err != nil {
+		return nil, err
+	}
+
+	next := r.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The authorization key is never written to disk.
+	record := exchange{}
+	record.Request.Method = req.Method
+	record.Request.URL = req.URL.String()
+	record.Request.Body = string(reqBody)
+	record.Response.StatusCode = resp.StatusCode
+	record.Response.Header = resp.Header
+	record.Response.Body = string(respBody)
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, name+".json"), data, 0o644); err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// replayer serves recorded responses without sending requests.
+type replayer struct {
+	dir string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, _, err := fingerprint(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.dir, name+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+		}
+		return nil, err
+	}
+
+	record := exchange{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", record.Response.StatusCode, http.StatusText(record.Response.StatusCode)),
+		StatusCode:    record.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        record.Response.Header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(record.Response.Body))),
+		ContentLength: int64(len(record.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// SetRecorder is used to record every request and response to the directory.
+// Each exchange is saved as a JSON file named after a hash of the request method, URL and body.
+// JSON and multipart bodies, such as those of NewChat and Transcribe, are hashed in a canonical form,
+// so that the same request can be replayed. The authorization key is not recorded.
+// An empty dir disables recording.
+func (c *Chat) SetRecorder(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.recordDir = dir
+}
+
+// SetReplayer is used to serve responses recorded by SetRecorder instead of calling the API.
+// A request with no matching recording fails. While replaying, nothing is recorded.
+// An empty dir disables replaying.
+func (c *Chat) SetReplayer(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.replayDir = dir
+}
+
+// wrapTransport returns the transport recording or replaying requests sent by next,
+// or next if neither is enabled. The caller must hold the mutex.
+func (c *Chat) wrapTransport(next http.RoundTripper) http.RoundTripper {
+	if c.replayDir != "" {
+		return &replayer{dir: c.replayDir}
+	}
+	if c.recordDir != "" {
+		return &recorder{dir: c.recordDir, next: next}
+	}
+
+	return next
+}
@@ -0,0 +1,104 @@
+// @file recorder_test.go
+// @brief Tests of the record and replay of HTTP exchanges.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	requests := 0
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if strings.HasSuffix(r.URL.Path, "/audio/transcriptions") {
+			io.WriteString(w, `{"text":"Hello from the recording."}`)
+			return
+		}
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	transcribe := func() (*TranscriptionResponse, error) {
+		return c.Transcribe(context.Background(), strings.NewReader("fake audio"), "hello.mp3", "",
+			WithTranscriptionLanguage("en"), WithTranscriptionPrompt("A greeting."), WithTranscriptionTemperature(0))
+	}
+
+	c.SetRecorder(dir)
+	c.AddMessageAsUser("Hi")
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+	if _, err := transcribe(); err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 || requests != 2 {
+		t.Fatalf("%d recordings of %d requests, want 2", len(files), requests)
+	}
+
+	// Each multipart body has a new boundary and its fields in any order, but is replayed all the same.
+	c.SetReplayer(dir)
+	c.SetRecorder("")
+	for i := 0; i < 5; i++ {
+		res, err := transcribe()
+		if err != nil {
+			t.Fatalf("replayed Transcribe: %v", err)
+		}
+		if res.Text != "Hello from the recording." {
+			t.Errorf("text = %q, want the recorded text", res.Text)
+		}
+	}
+	c.SetMessages([]Message{{Role: "user", Content: "Hi"}})
+	if reply, err := c.NewChatText(); err != nil || len(reply) != 1 || reply[0] != "Hello!" {
+		t.Errorf("replayed NewChatText = %q, %v, want the recorded reply", reply, err)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests sent, want none while replaying", requests-2)
+	}
+
+	c.SetReplayer("")
+	if _, err := transcribe(); err != nil || requests != 3 {
+		t.Errorf("Transcribe = %v after %d requests, want it sent once replaying is disabled", err, requests)
+	}
+}
+
+func TestCanonicalBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		a, b        string
+	}{
+		{
+			name:        "json",
+			contentType: "application/json",
+			a:           `{"model":"gpt-4o","n":1,"messages":[{"role":"user","content":"Hi"}]}`,
+			b:           `{"n":1, "messages":[{"content":"Hi","role":"user"}], "model":"gpt-4o"}`,
+		},
+		{
+			name:        "multipart",
+			contentType: "multipart/form-data; boundary=X",
+			a:           "--X\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--X\r\nContent-Disposition: form-data; name=\"b\"\r\n\r\n2\r\n--X--\r\n",
+			b:           "--X\r\nContent-Disposition: form-data; name=\"b\"\r\n\r\n2\r\n--X\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--X--\r\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := canonicalBody(test.contentType, []byte(test.a)), canonicalBody(test.contentType, []byte(test.b))
+			if string(a) != string(b) {
+				t.Errorf("canonical bodies differ:\n%q\n%q", a, b)
+			}
+		})
+	}
+
+	if body := canonicalBody("text/plain", []byte("raw")); string(body) != "raw" {
+		t.Errorf("canonical text body = %q, want it unchanged", body)
+	}
+}
@@ -0,0 +1,559 @@
+// @file stream.go
+// @brief Streaming Chat API implementation for OpenAI GPT-3.5 API. (https://platform.openai.com/docs/api-reference/chat/streaming)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// ErrStreamClosed is returned by Recv after the stream is closed with Close.
+var ErrStreamClosed = errors.New("stream closed")
+
+// Delta is the delta object is used to represent a partial message in a streamed chat completion.
+type Delta struct {
+	// Role is the role of the message. Only set in the first chunk.
+	Role Role `json:"role,omitempty"`
+	// Content is the content added by the chunk.
+	Content string `json:"content"`
+	// Refusal is the part of the refusal added by the chunk, when the model declines the request.
+	Refusal string `json:"refusal,omitempty"`
+	// ToolCalls is the fragments of tool calls added by the chunk.
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a tool call in a streamed chat completion chunk.
+// The first fragment of a call has its ID, type and function name, and the
+// arguments are spread across the fragments with the same index.
+type ToolCallDelta struct {
+	// Index is the position of the tool call in the message.
+	Index int `json:"index"`
+	// ID is the ID of the tool call. Only set in the first fragment.
+	ID string `json:"id,omitempty"`
+	// Type is the type of the tool. Only set in the first fragment.
+	Type string `json:"type,omitempty"`
+	// Function is the fragment of the function call.
+	Function FunctionCall `json:"function"`
+}
+
+// StreamChoice is the choice object is used to represent a choice in a streamed chat completion chunk.
+type StreamChoice struct {
+	// The index of the choice.
+	Index int `json:"index"`
+	// Delta is the partial message of the chunk.
+	Delta Delta `json:"delta"`
+	// FinishReason is the reason the chat completion stopped. Only set in the last chunk of the choice.
+	FinishReason string `json:"finish_reason"`
+	// Logprobs is the log probabilities of the tokens of the chunk, only set if SetLogprobs is enabled.
+	Logprobs *Logprobs `json:"logprobs"`
+}
+
+// ChatStreamResponse is the chat completion chunk object is used to represent a streamed chat completion chunk.
+type ChatStreamResponse struct {
+	// ID is the ID of the chat completion. Each chunk has the same ID.
+	ID string `json:"id"`
+	// Object is the object type of the chat completion chunk.
+	Object string `json:"object"`
+	// Created is the timestamp of when the chat completion was created.
+	Created int `json:"created"`
+	// Model is the ID of the model used to generate the chat completion.
+	Model string `json:"model"`
+	// Choices is the list of chat completion choices.
+	Choices []StreamChoice `json:"choices"`
+	// SystemFingerprint is the backend configuration the model runs with.
+	SystemFingerprint string `json:"system_fingerprint"`
+	// Usage is the usage of the whole request. Only set in the last chunk, which has no choices,
+	// when SetStreamIncludeUsage is enabled.
+	Usage *Usage `json:"usage"`
+}
+
+// Stream is a streamed chat completion.
+type Stream struct {
+	// Chat the stream belongs to
+	chat *Chat
+	// Response of the request
+	resp *http.Response
+	// Reader of the response body
+	reader *bufio.Reader
+	// Data of the event being received, until it is complete
+	pending []byte
+	// Content received so far, by choice index
+	contents []*strings.Builder
+	// Finish reasons received so far, by choice index
+	finishReasons []string
+	// Tool calls received so far, by choice index
+	toolCalls [][]ToolCall
+	// Refusals received so far, by choice index
+	refusals []string
+	// First chunk of the stream
+	first *ChatStreamResponse
+	// Usage of the request, from the last chunk
+	usage Usage
+	// Whether the stream has ended
+	done bool
+	// Releases the timeout of the request
+	cancel context.CancelFunc
+	// Whether Close has been called, accessed atomically
+	closed int32
+	// Context of the request, to reconnect
+	ctx context.Context
+	// Request data and messages, to reconnect
+	params map[string]interface{}
+	// Number of reconnections left
+	reconnects int
+	// Number of characters at which the content of a choice is cut off, 0 for none
+	maxLength int
+	// Number of characters received so far, by choice index
+	lengths []int
+	// Guards resp, which is replaced when reconnecting while Close may be called
+	mutex sync.Mutex
+}
+
+// NewChatStream sends the chat request with streaming enabled.
+// Call Recv to read the chunks until it returns io.EOF. The content of the streamed
+// assistant message is appended to the messages once the stream completes, unless SetAutoAppend is disabled.
+// Like NewChat, it returns ErrMultipleChoices with SetN above 1 unless SetAutoAppend is disabled.
+func (c *Chat) NewChatStream(ctx context.Context) (*Stream, error) {
+	if err := c.checkChoices(); err != nil {
+		return nil, err
+	}
+	c.trimHistory()
+	ctx, cancel := c.withTimeout(ctx)
+
+	params := map[string]interface{}{"stream": true}
+	c.mutex.RLock()
+	if c.streamUsage {
+		params["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	reconnects, maxLength := c.streamReconnects, c.streamMaxLength
+	if reconnects > 0 {
+		// Keep the messages of the request to send them again when reconnecting.
+		params["messages"] = copyMessages(c.messages)
+	}
+	c.mutex.RUnlock()
+
+	resp, err := c.openStream(ctx, params)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Stream{
+		chat:       c,
+		resp:       resp,
+		reader:     bufio.NewReader(resp.Body),
+		cancel:     cancel,
+		ctx:        ctx,
+		params:     params,
+		reconnects: reconnects,
+		maxLength:  maxLength,
+	}, nil
+}
+
+// openStream sends the streamed chat request and returns the response once its status is checked.
+func (c *Chat) openStream(ctx context.Context, params map[string]interface{}) (*http.Response, error) {
+	req, err := c.newRequest(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// send request
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSuccess(resp.StatusCode) {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		return nil, newAPIError(resp.StatusCode, body)
+	}
+
+	return resp, nil
+}
+
+// SetStreamAutoReconnect is used to resume streams whose connection breaks before they end,
+// at most maxAttempts times per stream. A maxAttempts of 0 disables it.
+//
+// The API cannot resume a stream, so the request is sent again with the content received so far
+// as an assistant message and a user message asking to continue it. The continuation is then
+// streamed as part of the same reply. The model may not continue seamlessly, for example repeating
+// a few words, and the usage only covers the last request. Streams with SetN above 1 or with
+// tool calls are not resumed.
+func (c *Chat) SetStreamAutoReconnect(maxAttempts int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.streamReconnects = maxAttempts
+}
+
+// reconnect sends the request of the stream again to continue the content received so far.
+// It reports whether the stream was resumed.
+func (s *Stream) reconnect() bool {
+	if s.reconnects <= 0 || atomic.LoadInt32(&s.closed) == 1 || s.ctx.Err() != nil ||
+		len(s.contents) > 1 || (len(s.toolCalls) > 0 && len(s.toolCalls[0]) > 0) {
+		return false
+	}
+	s.reconnects--
+
+	params := map[string]interface{}{}
+	for key, value := range s.params {
+		params[key] = value
+	}
+	if len(s.contents) > 0 && s.contents[0].Len() > 0 {
+		messages, _ := s.params["messages"].([]Message)
+		params["messages"] = append(copyMessages(messages),
+			Message{Role: RoleAssistant, Content: s.contents[0].String()},
+			Message{Role: RoleUser, Content: continuePrompt},
+		)
+	}
+
+	resp, err := s.chat.openStream(s.ctx, params)
+	if err != nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	s.resp.Body.Close()
+	s.resp = resp
+	s.mutex.Unlock()
+	s.reader = bufio.NewReader(resp.Body)
+	s.pending = nil
+	return true
+}
+
+// Recv returns the next chunk of the stream.
+// It returns io.EOF once the stream has ended with the data: [DONE] message.
+func (s *Stream) Recv() (*ChatStreamResponse, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil, ErrStreamClosed
+	}
+	if s.done {
+		return nil, io.EOF
+	}
+
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			if s.reconnect() {
+				continue
+			}
+			s.finish()
+			if atomic.LoadInt32(&s.closed) == 1 {
+				return nil, ErrStreamClosed
+			}
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, contextError(s.resp.Request.Context(), err)
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 && len(s.pending) > 0 {
+			// The blank line ends the event, so its data must be complete.
+			data := s.pending
+			s.pending = nil
+			s.finish()
+			return nil, fmt.Errorf("invalid stream event: %q", snippet(data))
+		}
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+
+		// A chunk may be split across several data lines, which are joined until they form a whole JSON object.
+		// Only the space after the colon is removed, as the split may fall inside a string.
+		s.pending = append(s.pending, bytes.TrimPrefix(line[len("data:"):], []byte(" "))...)
+		if string(bytes.TrimSpace(s.pending)) != "[DONE]" && !json.Valid(s.pending) {
+			continue
+		}
+		data := bytes.TrimSpace(s.pending)
+		s.pending = nil
+
+		if string(data) == "[DONE]" {
+			s.finish()
+			s.complete()
+			return nil, io.EOF
+		}
+
+		chunk := &ChatStreamResponse{}
+		if err := json.Unmarshal(data, chunk); err != nil {
+			s.finish()
+			return nil, err
+		}
+
+		if s.first == nil {
+			s.first = chunk
+		}
+		if chunk.Usage != nil {
+			s.usage = *chunk.Usage
+		}
+		cutOff := false
+		for index := range chunk.Choices {
+			choice := &chunk.Choices[index]
+			for len(s.contents) <= choice.Index {
+				s.contents = append(s.contents, &strings.Builder{})
+				s.finishReasons = append(s.finishReasons, "")
+				s.toolCalls = append(s.toolCalls, nil)
+				s.refusals = append(s.refusals, "")
+				s.lengths = append(s.lengths, 0)
+			}
+			if s.maxLength > 0 {
+				var reached bool
+				choice.Delta.Content, reached = truncate(choice.Delta.Content, s.maxLength-s.lengths[choice.Index])
+				s.lengths[choice.Index] += utf8.RuneCountInString(choice.Delta.Content)
+				cutOff = cutOff || reached
+			}
+			s.contents[choice.Index].WriteString(choice.Delta.Content)
+			s.refusals[choice.Index] += choice.Delta.Refusal
+			s.addToolCalls(choice.Index, choice.Delta.ToolCalls)
+			if choice.FinishReason != "" {
+				s.finishReasons[choice.Index] = choice.FinishReason
+			}
+		}
+
+		if cutOff {
+			// End the stream here, as if the reply had been cut off by the token limit.
+			for index := range s.finishReasons {
+				if s.finishReasons[index] == "" {
+					s.finishReasons[index] = "length"
+				}
+			}
+			s.finish()
+			s.complete()
+		}
+
+		return chunk, nil
+	}
+}
+
+// truncate returns the first n characters of the text, and whether the text has at least n characters.
+func truncate(text string, n int) (string, bool) {
+	count := 0
+	for index := range text {
+		if count >= n {
+			return text[:index], true
+		}
+		count++
+	}
+
+	return text, count >= n
+}
+
+// addToolCalls adds the fragments of tool calls to the calls of the choice.
+func (s *Stream) addToolCalls(choice int, deltas []ToolCallDelta) {
+	for _, delta := range deltas {
+		calls := s.toolCalls[choice]
+		for len(calls) <= delta.Index {
+			calls = append(calls, ToolCall{})
+		}
+		call := &calls[delta.Index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+		s.toolCalls[choice] = calls
+	}
+}
+
+// ToolCalls returns the tool calls of the first choice, assembled from their fragments.
+// It returns nil until the choice has finished, as the arguments are incomplete before.
+func (s *Stream) ToolCalls() []ToolCall {
+	if len(s.finishReasons) == 0 || s.finishReasons[0] == "" || len(s.toolCalls[0]) == 0 {
+		return nil
+	}
+
+	calls := make([]ToolCall, len(s.toolCalls[0]))
+	copy(calls, s.toolCalls[0])
+	return calls
+}
+
+// Usage returns the token usage of the request. It is only set once the last chunk has been
+// received, and only if SetStreamIncludeUsage is enabled.
+func (s *Stream) Usage() Usage {
+	return s.usage
+}
+
+// Close is used to stop reading the stream before it ends, releasing the connection.
+// A Recv blocked in another goroutine returns, and later calls to Recv return ErrStreamClosed.
+// The partial reply is not appended to the messages. Cancelling the context passed to
+// NewChatStream has the same effect on the connection.
+func (s *Stream) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	s.cancel()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.resp.Body.Close()
+}
+
+// complete stores the response assembled from the chunks in the chat,
+// and appends its reply to the messages unless SetAutoAppend is disabled.
+func (s *Stream) complete() {
+	res := s.response()
+	s.chat.addUsage(res)
+	s.chat.storeResponse(res)
+	if s.chat.autoAppend() {
+		s.chat.CommitResponse(res)
+	}
+}
+
+// finish marks the stream as ended and closes the response body.
+func (s *Stream) finish() {
+	s.done = true
+	s.mutex.Lock()
+	s.resp.Body.Close()
+	s.mutex.Unlock()
+	s.cancel()
+}
+
+// response assembles the chunks received so far into a chat completion.
+func (s *Stream) response() *ChatResponse {
+	res := &ChatResponse{Object: "chat.completion"}
+	if s.first != nil {
+		res.ID = s.first.ID
+		res.Created = s.first.Created
+		res.Model = s.first.Model
+		res.SystemFingerprint = s.first.SystemFingerprint
+	}
+	res.Usages = s.usage
+	for index := range s.contents {
+		res.Choices = append(res.Choices, Choice{
+			Index: index,
+			Msg: Message{
+				Role:      RoleAssistant,
+				Content:   s.contents[index].String(),
+				Refusal:   s.refusals[index],
+				ToolCalls: s.toolCalls[index],
+			},
+			FinishReason: s.finishReasons[index],
+		})
+	}
+
+	return res
+}
+
+// NewChatStreamFunc sends the chat request with streaming enabled and calls fn with the content
+// of each delta as it arrives. With SetN above 1 and SetAutoAppend disabled, the deltas of the choices are interleaved.
+// If fn returns an error, the stream is closed and the error is returned.
+// Once the stream completes, the assembled chat completion is returned.
+func (c *Chat) NewChatStreamFunc(ctx context.Context, fn func(delta string) error) (*ChatResponse, error) {
+	stream, err := c.NewChatStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for index := range chunk.Choices {
+			if chunk.Choices[index].Delta.Content == "" {
+				continue
+			}
+			if err := fn(chunk.Choices[index].Delta.Content); err != nil {
+				stream.finish()
+				return nil, err
+			}
+		}
+	}
+
+	return stream.response(), nil
+}
+
+// streamReader reads the content of a stream.
+type streamReader struct {
+	// Stream being read
+	stream *Stream
+	// Content received but not read yet
+	buf []byte
+}
+
+// NewChatStreamReader sends the chat request with streaming enabled and returns a reader of the content
+// of the deltas as they arrive, for example to copy it to os.Stdout with io.Copy. Read returns io.EOF once
+// the stream completes, and the error of the stream if it fails. Close the reader to stop the stream early.
+func (c *Chat) NewChatStreamReader(ctx context.Context) (io.ReadCloser, error) {
+	stream, err := c.NewChatStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{stream: stream}, nil
+}
+
+// Read implements io.Reader.
+func (r *streamReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		for index := range chunk.Choices {
+			r.buf = append(r.buf, chunk.Choices[index].Delta.Content...)
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (r *streamReader) Close() error {
+	return r.stream.Close()
+}
+
+// SetStreamMaxLength is used to stop streams once the content of a choice reaches maxLength characters,
+// for example to show a short preview without waiting for the whole reply. The content is cut to
+// maxLength characters and the connection is closed: Recv returns the chunk that reached the limit,
+// then io.EOF. The stream then ends like a completed one, with FinishReason "length", so the partial
+// reply is appended to the messages unless SetAutoAppend is disabled, and can be resumed with Continue.
+// Characters are counted as Unicode code points. The API bills the tokens generated before the
+// connection closed, which may be a few more than were received. A maxLength of 0 disables it.
+func (c *Chat) SetStreamMaxLength(maxLength int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.streamMaxLength = maxLength
+}
+
+// SetStreamIncludeUsage is used to receive the token usage of streamed requests.
+// When enabled, the API sends a last chunk with the usage and no choices, see Stream.Usage.
+// It has no effect on requests that are not streamed.
+func (c *Chat) SetStreamIncludeUsage(include bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.streamUsage = include
+}
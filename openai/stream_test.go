@@ -0,0 +1,302 @@
+// @file stream_test.go
+// @brief Tests of the streaming Chat API against a mock OpenAI-compatible server.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFragments writes the body in pieces of at most size bytes, flushing each one,
+// so that the client receives it across many reads.
+func writeFragments(w http.ResponseWriter, body string, size int) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for len(body) > 0 {
+		n := size
+		if n > len(body) {
+			n = len(body)
+		}
+		io.WriteString(w, body[:n])
+		if flusher != nil {
+			flusher.Flush()
+		}
+		body = body[n:]
+	}
+}
+
+// readStream receives the chunks of the stream until it ends and returns the content of the first choice.
+func readStream(t *testing.T, stream *Stream) (string, error) {
+	t.Helper()
+
+	content := strings.Builder{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return content.String(), nil
+		}
+		if err != nil {
+			return content.String(), err
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+		}
+	}
+}
+
+func TestStreamFragmented(t *testing.T) {
+	large := strings.Repeat("word ", 40000)
+	tests := []struct {
+		name string
+		body string
+		size int
+		want string
+	}{
+		{
+			name: "split across reads",
+			body: "data: " + chunkJSON("Hel", "") + "\n\n" + "data: " + chunkJSON("lo!", "stop") + "\n\n" + "data: [DONE]\n\n",
+			size: 3,
+			want: "Hello!",
+		},
+		{
+			name: "split across data lines",
+			body: "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":\n" +
+				"data: {\"content\":\"Hel\"}}]}\n\n" +
+				"data:{\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo, \n" +
+				"data:  world!\"},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n",
+			size: 7,
+			want: "Hello,  world!",
+		},
+		{
+			name: "crlf and comments",
+			body: ": keep-alive\r\n\r\n" + "data: " + chunkJSON("Hi", "stop") + "\r\n\r\n" + "data: [DONE]\r\n\r\n",
+			size: 5,
+			want: "Hi",
+		},
+		{
+			name: "larger than a scanner buffer",
+			body: "data: " + chunkJSON(large, "stop") + "\n\n" + "data: [DONE]\n\n",
+			size: 4096,
+			want: large,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+				writeFragments(w, test.body, test.size)
+			})
+			c.AddMessageAsUser("Hi")
+
+			stream, err := c.NewChatStream(context.Background())
+			if err != nil {
+				t.Fatalf("NewChatStream: %v", err)
+			}
+			defer stream.Close()
+
+			content, err := readStream(t, stream)
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			if content != test.want {
+				t.Errorf("content = %.40q (%d bytes), want %.40q (%d bytes)", content, len(content), test.want, len(test.want))
+			}
+			if reply, ok := c.LastAssistantMessage(); !ok || reply.Content != test.want {
+				t.Errorf("appended reply has %d bytes, want %d", len(reply.Content), len(test.want))
+			}
+		})
+	}
+}
+
+func TestStreamMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"incomplete event", "data: {\"id\":\"chatcmpl-1\",\"choices\":[\n\n", "invalid stream event"},
+		{"cut off", "data: " + chunkJSON("Hel", "") + "\n\n", io.ErrUnexpectedEOF.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+				writeFragments(w, test.body, 4)
+			})
+			c.AddMessageAsUser("Hi")
+
+			stream, err := c.NewChatStream(context.Background())
+			if err != nil {
+				t.Fatalf("NewChatStream: %v", err)
+			}
+			defer stream.Close()
+
+			if _, err := readStream(t, stream); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Recv error = %v, want %s", err, test.want)
+			}
+			if count := c.MessageCount(); count != 1 {
+				t.Errorf("message count = %d, want no partial reply appended", count)
+			}
+		})
+	}
+}
+
+// newBlockingStream returns a stream whose server sends one chunk, then waits until the client
+// goes away, and a channel closed once the server has seen the connection close.
+func newBlockingStream(t *testing.T, ctx context.Context) (*Stream, chan struct{}) {
+	t.Helper()
+
+	gone := make(chan struct{})
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		writeFragments(w, "data: "+chunkJSON("Hel", "")+"\n\n", 1<<10)
+		<-r.Context().Done()
+		close(gone)
+	})
+	c.AddMessageAsUser("Hi")
+
+	stream, err := c.NewChatStream(ctx)
+	if err != nil {
+		t.Fatalf("NewChatStream: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	return stream, gone
+}
+
+// waitClosed fails the test if the server does not see the connection close.
+func waitClosed(t *testing.T, gone chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-gone:
+	case <-time.After(5 * time.Second):
+		t.Error("the connection is still open")
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	stream, gone := newBlockingStream(t, context.Background())
+
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := stream.Recv(); err != ErrStreamClosed {
+		t.Errorf("Recv after Close = %v, want ErrStreamClosed", err)
+	}
+	if _, err := stream.resp.Body.Read(make([]byte, 1)); err == nil {
+		t.Error("the body is still readable after Close")
+	}
+	waitClosed(t, gone)
+	if count := stream.chat.MessageCount(); count != 1 {
+		t.Errorf("message count = %d, want no partial reply appended", count)
+	}
+}
+
+func TestStreamCloseWhileReceiving(t *testing.T) {
+	stream, gone := newBlockingStream(t, context.Background())
+
+	received := make(chan error)
+	go func() {
+		_, err := stream.Recv()
+		received <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stream.Close()
+
+	select {
+	case err := <-received:
+		if err != ErrStreamClosed {
+			t.Errorf("blocked Recv = %v, want ErrStreamClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Recv is still blocked after Close")
+	}
+	waitClosed(t, gone)
+}
+
+func TestStreamContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, gone := newBlockingStream(t, ctx)
+	defer stream.Close()
+
+	cancel()
+	if _, err := stream.Recv(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Recv after cancel = %v, want context.Canceled", err)
+	}
+	waitClosed(t, gone)
+}
+
+func TestStreamMaxLength(t *testing.T) {
+	gone := make(chan struct{})
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		body := ""
+		for _, content := range []string{"Héllo", " wor", "ld, and much more"} {
+			body += "data: " + chunkJSON(content, "") + "\n\n"
+		}
+		writeFragments(w, body, len(body))
+		<-r.Context().Done()
+		close(gone)
+	})
+	c.SetStreamMaxLength(8)
+	c.AddMessageAsUser("Hi")
+
+	stream, err := c.NewChatStream(context.Background())
+	if err != nil {
+		t.Fatalf("NewChatStream: %v", err)
+	}
+	defer stream.Close()
+
+	var deltas []string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		deltas = append(deltas, chunk.Choices[0].Delta.Content)
+	}
+
+	if got := strings.Join(deltas, "|"); got != "Héllo| wo" {
+		t.Errorf("deltas = %q, want Héllo| wo", got)
+	}
+	waitClosed(t, gone)
+	if reply, ok := c.LastAssistantMessage(); !ok || reply.Content != "Héllo wo" {
+		t.Errorf("appended reply = %q, want Héllo wo", reply.Content)
+	}
+	if reason := c.LastFinishReason(); reason != "length" {
+		t.Errorf("finish reason = %q, want length", reason)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text    string
+		n       int
+		want    string
+		reached bool
+	}{
+		{"hello", 10, "hello", false},
+		{"hello", 5, "hello", true},
+		{"hello", 3, "hel", true},
+		{"héllo", 2, "hé", true},
+		{"", 1, "", false},
+	}
+	for _, test := range tests {
+		got, reached := truncate(test.text, test.n)
+		if got != test.want || reached != test.reached {
+			t.Errorf("truncate(%q, %d) = %q, %v, want %q, %v", test.text, test.n, got, reached, test.want, test.reached)
+		}
+	}
+}
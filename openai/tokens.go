@@ -0,0 +1,172 @@
+// @file tokens.go
+// @brief Token counting of the messages for OpenAI Chat API. (https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// tokensPerMessage is the number of tokens wrapping each message.
+	tokensPerMessage = 3
+	// tokensPerReply is the number of tokens priming the reply of the assistant.
+	tokensPerReply = 3
+	// tokensPerName is the number of tokens added by the name of a message, on top of the name itself.
+	tokensPerName = 1
+	// tokensPerImage is the number of tokens of a low detail image, the minimum for any image.
+	tokensPerImage = 85
+)
+
+// EstimateTokens returns the number of prompt tokens of the messages, including the tokens the API adds
+// around each message, counted like tiktoken with the encoding of the model: o200k_base for gpt-4o and
+// the reasoning models, cl100k_base for gpt-4, gpt-3.5-turbo and models unknown to tiktoken.
+//
+// The text of the messages is counted exactly, so the count matches the usage reported by the API
+// for messages of text. It is an estimate otherwise: images are counted as low detail images,
+// and tools and response formats are not counted. It returns an error if a message has audio
+// or file parts, whose tokens cannot be estimated.
+//
+// The count of each message is cached, so counting again after adding a message only counts the new message.
+// The encodings are loaded on first use, which takes some memory and a fraction of a second.
+func (c *Chat) EstimateTokens() (int, error) {
+	return c.EstimateTokensWithContext(context.Background())
+}
+
+// EstimateTokensWithContext is like EstimateTokens, but it stops when the context is done and returns its error,
+// for example to bound the time spent counting a very long conversation.
+func (c *Chat) EstimateTokensWithContext(ctx context.Context) (int, error) {
+	c.mutex.RLock()
+	messages := make([]Message, len(c.messages))
+	copy(messages, c.messages)
+	cache := c.tokenCounts
+	c.mutex.RUnlock()
+
+	counts, cache, err := countMessages(ctx, encodingForModel(c.model()), messages, cache)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	c.tokenCounts = cache
+	c.mutex.Unlock()
+
+	return sumTokens(counts), nil
+}
+
+// messageKey is the text of a message that its token count depends on.
+type messageKey struct {
+	role          Role
+	name, content string
+	// Text of the parts and tool calls
+	extra string
+}
+
+// newMessageKey returns the key of the message in the token count cache.
+func newMessageKey(message Message) messageKey {
+	key := messageKey{role: message.Role, name: message.Name, content: message.Content}
+	if len(message.Parts) == 0 && len(message.ToolCalls) == 0 {
+		return key
+	}
+
+	extra := strings.Builder{}
+	for _, part := range message.Parts {
+		extra.WriteString(part.Type)
+		extra.WriteByte(0)
+		extra.WriteString(part.Text)
+		extra.WriteByte(0)
+	}
+	for _, call := range message.ToolCalls {
+		extra.WriteString(call.Function.Name)
+		extra.WriteByte(0)
+		extra.WriteString(call.Function.Arguments)
+		extra.WriteByte(0)
+	}
+	key.extra = extra.String()
+	return key
+}
+
+// tokenCache is the token counts of messages with an encoding, by message.
+type tokenCache struct {
+	encoding *encoding
+	counts   map[messageKey]int
+}
+
+// countMessages returns the number of tokens of each message with the encoding. Counts found in the cache
+// are not counted again. It also returns the cache of the messages, with the count of each of them.
+// The cache is replaced rather than modified, so that it can be read without holding the mutex.
+func countMessages(ctx context.Context, enc *encoding, messages []Message, cache *tokenCache) ([]int, *tokenCache, error) {
+	var cached map[messageKey]int
+	if cache != nil && cache.encoding == enc {
+		cached = cache.counts
+	}
+
+	counts := make([]int, len(messages))
+	next := &tokenCache{encoding: enc, counts: make(map[messageKey]int, len(messages))}
+	for index := range messages {
+		key := newMessageKey(messages[index])
+		count, ok := cached[key]
+		if !ok {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+			var err error
+			if count, err = countMessage(enc, messages[index]); err != nil {
+				return nil, nil, err
+			}
+		}
+		counts[index] = count
+		next.counts[key] = count
+	}
+
+	return counts, next, nil
+}
+
+// sumTokens returns the number of prompt tokens of messages with the counts, including the tokens priming the reply.
+func sumTokens(counts []int) int {
+	tokens := tokensPerReply
+	for _, count := range counts {
+		tokens += count
+	}
+
+	return tokens
+}
+
+// countMessage returns the number of tokens of the message with the encoding.
+func countMessage(enc *encoding, message Message) (int, error) {
+	texts := []string{string(message.Role), message.Content}
+	tokens := tokensPerMessage
+	if message.Name != "" {
+		texts = append(texts, message.Name)
+		tokens += tokensPerName
+	}
+	for _, part := range message.Parts {
+		switch part.Type {
+		case PartText:
+			texts = append(texts, part.Text)
+		case PartImageURL:
+			tokens += tokensPerImage
+		default:
+			return 0, fmt.Errorf("cannot estimate tokens of %s parts", part.Type)
+		}
+	}
+	for _, call := range message.ToolCalls {
+		texts = append(texts, call.Function.Name, call.Function.Arguments)
+	}
+
+	for _, text := range texts {
+		count, err := enc.count(text)
+		if err != nil {
+			return 0, err
+		}
+		tokens += count
+	}
+
+	return tokens, nil
+}
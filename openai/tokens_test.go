@@ -0,0 +1,250 @@
+// @file tokens_test.go
+// @brief Tests of the token counts of the messages, against the counts of tiktoken.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestEstimateTokensWithContext(t *testing.T) {
+	c := &Chat{}
+	c.AddMessageAsUser("Hello, world!")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.EstimateTokensWithContext(ctx); err != context.Canceled {
+		t.Errorf("EstimateTokensWithContext with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	// Once cached, the count needs no estimate, so the context is not checked.
+	tokens, err := c.EstimateTokens()
+	if err != nil {
+		t.Fatalf("EstimateTokens: %v", err)
+	}
+	if cached, err := c.EstimateTokensWithContext(ctx); err != nil || cached != tokens {
+		t.Errorf("EstimateTokensWithContext = %d, %v, want the cached %d", cached, err, tokens)
+	}
+}
+
+func TestTokenCountCache(t *testing.T) {
+	c := &Chat{}
+	c.AddMessageAsSystem("You are a helpful assistant.")
+	for index := 0; index < 5; index++ {
+		c.AddMessageAsUser(fmt.Sprintf("Question %d?", index))
+		c.AddMessageAsAssistant(fmt.Sprintf("Answer %d.", index))
+
+		tokens, err := c.EstimateTokens()
+		if err != nil {
+			t.Fatalf("EstimateTokens: %v", err)
+		}
+		want, _, err := countMessages(context.Background(), cl100kBase, c.GetMessages(), nil)
+		if err != nil {
+			t.Fatalf("countMessages: %v", err)
+		}
+		if tokens != sumTokens(want) {
+			t.Errorf("cached estimate = %d, want %d", tokens, sumTokens(want))
+		}
+	}
+
+	c.ClearMessages()
+	c.AddMessageAsUser("Only question?")
+	if _, err := c.EstimateTokens(); err != nil {
+		t.Fatalf("EstimateTokens: %v", err)
+	}
+	if size := len(c.tokenCounts.counts); size != 1 {
+		t.Errorf("cache has %d counts, want only the count of the current message", size)
+	}
+
+	// The counts of another encoding are not used.
+	c.SetMessages(cookbookMessages)
+	if tokens, err := c.EstimateTokens(); err != nil || tokens != 129 {
+		t.Errorf("estimate with cl100k_base = %d, %v, want 129", tokens, err)
+	}
+	c.SetModel("gpt-4o")
+	if tokens, err := c.EstimateTokens(); err != nil || tokens != 124 {
+		t.Errorf("estimate with o200k_base = %d, %v, want 124", tokens, err)
+	}
+}
+
+func TestTrimHistoryWithCache(t *testing.T) {
+	c := &Chat{}
+	c.AddMessageAsSystem("You are a helpful assistant.")
+	for index := 0; index < 10; index++ {
+		c.AddMessageAsUser(fmt.Sprintf("Question %d?", index))
+		c.AddMessageAsAssistant(fmt.Sprintf("Answer %d.", index))
+	}
+	c.AddMessageAsUser("Last question?")
+	c.SetMaxHistoryTokens(60)
+
+	c.trimHistory()
+	tokens, err := c.EstimateTokens()
+	if err != nil {
+		t.Fatalf("EstimateTokens: %v", err)
+	}
+	if tokens > 60 {
+		t.Errorf("estimate after trimming = %d, want at most 60", tokens)
+	}
+	messages := c.GetMessages()
+	if messages[0].Role != "system" || messages[len(messages)-1].Content != "Last question?" {
+		t.Errorf("messages = %+v, want the system message and the last question kept", messages)
+	}
+	if len(messages) >= 22 {
+		t.Errorf("%d messages left, want the oldest exchanges removed", len(messages))
+	}
+}
+
+// cookbookMessages are the example messages of the OpenAI cookbook on counting tokens, whose prompt tokens
+// are 129 with the cl100k_base encoding of gpt-3.5-turbo and gpt-4, and 124 with the o200k_base encoding of gpt-4o.
+var cookbookMessages = []Message{
+	{Role: "system", Content: "You are a helpful, pattern-following assistant that translates corporate jargon into plain English."},
+	{Role: "system", Name: "example_user", Content: "New synergies will help drive top-line growth."},
+	{Role: "system", Name: "example_assistant", Content: "Things working well together will increase revenue."},
+	{Role: "system", Name: "example_user", Content: "Let's circle back when we have more bandwidth to touch base on opportunities for increased leverage."},
+	{Role: "system", Name: "example_assistant", Content: "Let's talk later when we're less busy about how to do better."},
+	{Role: "user", Content: "This late pivot means we don't have time to boil the ocean for the client deliverable."},
+}
+
+func TestEstimateTokensAccuracy(t *testing.T) {
+	tests := []struct {
+		model  string
+		tokens int
+	}{
+		{"gpt-3.5-turbo-0613", 129},
+		{"gpt-4", 129},
+		{"gpt-4-turbo", 129},
+		{"gpt-4o", 124},
+		{"gpt-4o-mini", 124},
+		{"o1", 124},
+		{"unknown-model", 129},
+	}
+	for _, test := range tests {
+		t.Run(test.model, func(t *testing.T) {
+			c := &Chat{}
+			c.SetModel(test.model)
+			c.SetMessages(cookbookMessages)
+			if tokens, err := c.EstimateTokens(); err != nil || tokens != test.tokens {
+				t.Errorf("EstimateTokens = %d, %v, want %d as counted by tiktoken", tokens, err, test.tokens)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		text          string
+		cl100k, o200k []int
+	}{
+		{"hello world", []int{15339, 1917}, []int{24912, 2375}},
+		{"tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}, []int{83, 8251, 2488, 382, 2212, 0}},
+		{"antidisestablishmentarianism", []int{519, 85342, 34500, 479, 8997, 2191}, []int{493, 129901, 376, 160388, 21203, 2367}},
+		{"2 + 2 = 4", []int{17, 489, 220, 17, 284, 220, 19}, []int{17, 659, 220, 17, 314, 220, 19}},
+		{"お誕生日おめでとう", nil, []int{8930, 9697, 243, 128225, 8930, 17693, 4344, 48669}},
+		{"", []int{}, []int{}},
+	}
+	for _, test := range tests {
+		for _, enc := range []struct {
+			encoding *encoding
+			want     []int
+		}{{cl100kBase, test.cl100k}, {o200kBase, test.o200k}} {
+			if enc.want == nil {
+				continue
+			}
+			tokens, err := enc.encoding.encode(test.text)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if fmt.Sprint(tokens) != fmt.Sprint(enc.want) {
+				t.Errorf("%s tokens of %q = %v, want %v", enc.encoding.name, test.text, tokens, enc.want)
+			}
+		}
+	}
+
+	if count, err := cl100kBase.count("お誕生日おめでとう"); err != nil || count != 9 {
+		t.Errorf("cl100k_base count = %d, %v, want 9", count, err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		text          string
+		cl100k, o200k []string
+	}{
+		{
+			text:   "Hello WORLD's  test\n",
+			cl100k: []string{"Hello", " WORLD", "'s", " ", " test", "\n"},
+			o200k:  []string{"Hello", " WORLD's", " ", " test", "\n"},
+		},
+		{
+			text:   "HTTPServer they'RE 12345",
+			cl100k: []string{"HTTPServer", " they", "'RE", " ", "123", "45"},
+			o200k:  []string{"HTTPServer", " they'RE", " ", "123", "45"},
+		},
+		{
+			text:   "a//b\n\n  c",
+			cl100k: []string{"a", "//", "b", "\n\n", " ", " c"},
+			o200k:  []string{"a", "//", "b", "\n\n", " ", " c"},
+		},
+	}
+	for _, test := range tests {
+		for _, enc := range []struct {
+			encoding *encoding
+			want     []string
+		}{{cl100kBase, test.cl100k}, {o200kBase, test.o200k}} {
+			runes := []rune(test.text)
+			var pieces []string
+			for start := 0; start < len(runes); {
+				end := enc.encoding.split(runes, start)
+				pieces = append(pieces, string(runes[start:end]))
+				start = end
+			}
+			if fmt.Sprintf("%q", pieces) != fmt.Sprintf("%q", enc.want) {
+				t.Errorf("%s pieces of %q = %q, want %q", enc.encoding.name, test.text, pieces, enc.want)
+			}
+		}
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  *encoding
+	}{
+		{"gpt-4o", o200kBase},
+		{"gpt-4o-2024-08-06", o200kBase},
+		{"chatgpt-4o-latest", o200kBase},
+		{"gpt-4.1-mini", o200kBase},
+		{"o3-mini", o200kBase},
+		{"gpt-4", cl100kBase},
+		{"gpt-4-turbo", cl100kBase},
+		{"gpt-3.5-turbo", cl100kBase},
+		{"o1x", cl100kBase},
+		{"", cl100kBase},
+	}
+	for _, test := range tests {
+		if got := encodingForModel(test.model); got != test.want {
+			t.Errorf("encodingForModel(%q) = %s, want %s", test.model, got.name, test.want.name)
+		}
+	}
+}
+
+func BenchmarkEstimateTokens(b *testing.B) {
+	c := &Chat{}
+	for index := 0; index < 200; index++ {
+		c.AddMessageAsUser(fmt.Sprintf("Question %d about a rather long topic that takes a few tokens?", index))
+		c.AddMessageAsAssistant(fmt.Sprintf("Answer %d, with some detail about the rather long topic.", index))
+	}
+
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		if _, err := c.EstimateTokens(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// @file tools.go
+// @brief Function calling for OpenAI Chat API. (https://platform.openai.com/docs/guides/function-calling)
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Function describes a function the model may call.
+type Function struct {
+	// Name is the name of the function.
+	Name string `json:"name"`
+	// Description is what the function does, used by the model to choose when to call it.
+	Description string `json:"description,omitempty"`
+	// Parameters is the JSON schema object of the function arguments,
+	// such as a map[string]interface{} or a json.RawMessage.
+	Parameters interface{} `json:"parameters,omitempty"`
+}
+
+// Tool is a tool the model may call. Only function tools are supported by the API.
+type Tool struct {
+	// Type is the type of the tool. Defaults to "function".
+	Type string `json:"type"`
+	// Function is the function of the tool.
+	Function Function `json:"function"`
+}
+
+// FunctionCall is the function the model wants to call.
+type FunctionCall struct {
+	// Name is the name of the function.
+	Name string `json:"name"`
+	// Arguments is the JSON encoded arguments of the call, generated by the model.
+	// The model does not always generate valid JSON, so validate them before use.
+	Arguments string `json:"arguments"`
+}
+
+// ToolCall is a tool call requested by the model.
+type ToolCall struct {
+	// ID is the ID of the tool call, passed back with the result in AddMessageAsTool.
+	ID string `json:"id"`
+	// Type is the type of the tool. Always "function".
+	Type string `json:"type"`
+	// Function is the function the model wants to call.
+	Function FunctionCall `json:"function"`
+}
+
+// NewFunctionTool returns a function tool.
+func NewFunctionTool(name, description string, parameters interface{}) Tool {
+	return Tool{
+		Type: "function",
+		Function: Function{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+// SetTools tools array Optional;
+// A list of tools the model may call. When the model calls a tool, the choice has
+// FinishReason "tool_calls" and the calls are in Msg.ToolCalls. Send each result back
+// with AddMessageAsTool. Passing an empty list removes the tools.
+func (c *Chat) SetTools(tools []Tool) {
+	if len(tools) == 0 {
+		c.data.Delete("tools")
+		return
+	}
+
+	list := make([]Tool, len(tools))
+	copy(list, tools)
+	for index := range list {
+		if list[index].Type == "" {
+			list[index].Type = "function"
+		}
+	}
+	c.data.Store("tools", list)
+}
+
+// AddMessageAsTool is used to add the result of a tool call to the chat.
+func (c *Chat) AddMessageAsTool(toolCallID, content string) {
+	c.appendMessage(Message{Role: RoleTool, Content: content, ToolCallID: toolCallID})
+}
+
+// SetParallelToolCalls parallel_tool_calls boolean Optional Defaults to true;
+// Whether the model may request several tool calls in one reply. Disable it to receive at most one call per reply.
+// The parameter is only sent once set, so models that do not support it are not affected otherwise.
+func (c *Chat) SetParallelToolCalls(parallel bool) {
+	c.data.Store("parallel_tool_calls", parallel)
+}
+
+// functionChoice is the tool_choice value forcing a call of the named function.
+type functionChoice struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+// SetToolChoiceAuto tool_choice string Optional Defaults to auto when tools are set;
+// The model chooses whether to call tools and which ones.
+func (c *Chat) SetToolChoiceAuto() {
+	c.data.Store("tool_choice", "auto")
+}
+
+// SetToolChoiceNone is used to forbid tool calls, so that the model replies with a message.
+func (c *Chat) SetToolChoiceNone() {
+	c.data.Store("tool_choice", "none")
+}
+
+// SetToolChoiceRequired is used to make the model call one or more tools.
+func (c *Chat) SetToolChoiceRequired() {
+	c.data.Store("tool_choice", "required")
+}
+
+// SetToolChoiceFunction is used to make the model call the function with the name.
+func (c *Chat) SetToolChoiceFunction(name string) {
+	choice := functionChoice{Type: "function"}
+	choice.Function.Name = name
+	c.data.Store("tool_choice", choice)
+}
+
+// ForceTool is used to make the model call the function with the name, for a step of a workflow
+// that must run that function. It is the same as SetToolChoiceFunction.
+func (c *Chat) ForceTool(name string) {
+	c.SetToolChoiceFunction(name)
+}
+
+// HandleToolCalls is used to run the tool calls of the first choice of the response, for example in an agent loop.
+// The handler of each call is taken from handlers by function name and called with the arguments of the call,
+// and the result it returns is added with AddMessageAsTool. Calls run in order, so results are added in the
+// order of the calls. If a handler returns an error, the error is returned with the name of the tool and later
+// calls are not run. Calls of tools without a handler are skipped, and ErrUnknownTool is returned with their names
+// once the other calls have run. The API expects a result for every call, so add one for each skipped call
+// with AddMessageAsTool before sending the next request.
+func (c *Chat) HandleToolCalls(res *ChatResponse, handlers map[string]func(args json.RawMessage) (string, error)) error {
+	if res == nil || len(res.Choices) == 0 {
+		return nil
+	}
+
+	var unknown []string
+	for _, call := range res.Choices[0].Msg.ToolCalls {
+		handler, ok := handlers[call.Function.Name]
+		if !ok {
+			unknown = append(unknown, call.Function.Name)
+			continue
+		}
+
+		args := call.Function.Arguments
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		result, err := handler(json.RawMessage(args))
+		if err != nil {
+			return fmt.Errorf("tool %s: %w", call.Function.Name, err)
+		}
+		c.AddMessageAsTool(call.ID, result)
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownTool, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
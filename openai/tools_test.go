@@ -0,0 +1,170 @@
+// @file tools_test.go
+// @brief Tests of function calling for OpenAI Chat API.
+
+// Copyright (c) 2023 Wind. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// toolCallResponse returns a response whose first choice has the tool calls.
+func toolCallResponse(calls ...ToolCall) *ChatResponse {
+	return &ChatResponse{Choices: []Choice{{
+		Msg:          Message{Role: "assistant", ToolCalls: calls},
+		FinishReason: "tool_calls",
+	}}}
+}
+
+func TestForceTool(t *testing.T) {
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		choice, _ := json.Marshal(decodeRequest(t, r)["tool_choice"])
+		if string(choice) != `{"function":{"name":"get_weather"},"type":"function"}` {
+			t.Errorf("tool_choice = %s, want the object form naming get_weather", choice)
+		}
+		io.WriteString(w, completionJSON("Hello!"))
+	})
+	c.SetTools([]Tool{NewFunctionTool("get_weather", "Get the weather of a city.", nil)})
+	c.ForceTool("get_weather")
+	c.AddMessageAsUser("Weather in Paris?")
+
+	if _, err := c.NewChat(); err != nil {
+		t.Fatalf("NewChat: %v", err)
+	}
+}
+
+func TestHandleToolCalls(t *testing.T) {
+	c := &Chat{}
+	res := toolCallResponse(
+		ToolCall{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		ToolCall{ID: "call_2", Type: "function", Function: FunctionCall{Name: "send_email", Arguments: `{}`}},
+		ToolCall{ID: "call_3", Type: "function", Function: FunctionCall{Name: "get_time"}},
+		ToolCall{ID: "call_4", Type: "function", Function: FunctionCall{Name: "delete_files", Arguments: `{}`}},
+	)
+	handlers := map[string]func(args json.RawMessage) (string, error){
+		"get_weather": func(args json.RawMessage) (string, error) {
+			params := struct{ City string }{}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			return "sunny in " + params.City, nil
+		},
+		"get_time": func(args json.RawMessage) (string, error) {
+			if string(args) != "{}" {
+				t.Errorf("arguments = %s, want {} for empty arguments", args)
+			}
+			return "noon", nil
+		},
+	}
+
+	err := c.HandleToolCalls(res, handlers)
+	if !errors.Is(err, ErrUnknownTool) {
+		t.Fatalf("HandleToolCalls error = %v, want ErrUnknownTool", err)
+	}
+	if !strings.HasSuffix(err.Error(), "send_email, delete_files") {
+		t.Errorf("error = %v, want the names of the unknown tools", err)
+	}
+
+	want := []Message{
+		{Role: "tool", Content: "sunny in Paris", ToolCallID: "call_1"},
+		{Role: "tool", Content: "noon", ToolCallID: "call_3"},
+	}
+	messages := c.GetMessages()
+	if len(messages) != len(want) {
+		t.Fatalf("messages = %+v, want %+v", messages, want)
+	}
+	for index := range want {
+		if messages[index].Role != want[index].Role || messages[index].Content != want[index].Content ||
+			messages[index].ToolCallID != want[index].ToolCallID {
+			t.Errorf("message %d = %+v, want %+v", index, messages[index], want[index])
+		}
+	}
+}
+
+func TestHandleToolCallsHandlerError(t *testing.T) {
+	c := &Chat{}
+	res := toolCallResponse(
+		ToolCall{ID: "call_1", Function: FunctionCall{Name: "fail", Arguments: `{}`}},
+		ToolCall{ID: "call_2", Function: FunctionCall{Name: "ok", Arguments: `{}`}},
+	)
+	failure := errors.New("database unavailable")
+	handlers := map[string]func(args json.RawMessage) (string, error){
+		"fail": func(json.RawMessage) (string, error) { return "", failure },
+		"ok": func(json.RawMessage) (string, error) {
+			t.Error("a call after a failed call was run")
+			return "", nil
+		},
+	}
+
+	err := c.HandleToolCalls(res, handlers)
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "tool fail") {
+		t.Errorf("HandleToolCalls error = %v, want the handler error with the tool name", err)
+	}
+	if count := c.MessageCount(); count != 0 {
+		t.Errorf("message count = %d, want 0", count)
+	}
+	if err := c.HandleToolCalls(nil, handlers); err != nil {
+		t.Errorf("HandleToolCalls(nil) = %v, want nil", err)
+	}
+}
+
+func TestToolCallLoop(t *testing.T) {
+	requests := 0
+	c := newTestChat(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body := decodeRequest(t, r)
+		messages, _ := body["messages"].([]interface{})
+		if requests == 1 {
+			io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant",`+
+				`"content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},`+
+				`"finish_reason":"tool_calls"}]}`)
+			return
+		}
+
+		// The reply with the call and the result must both be sent back.
+		if len(messages) != 3 {
+			t.Errorf("messages = %v, want the question, the call and the result", messages)
+			http.Error(w, "unexpected messages", http.StatusBadRequest)
+			return
+		}
+		call, _ := messages[1].(map[string]interface{})
+		if calls, _ := call["tool_calls"].([]interface{}); len(calls) != 1 {
+			t.Errorf("assistant message = %v, want its tool call", call)
+		}
+		result, _ := messages[2].(map[string]interface{})
+		if result["role"] != "tool" || result["tool_call_id"] != "call_1" || result["content"] != "sunny" {
+			t.Errorf("tool message = %v, want the result of call_1", result)
+		}
+		io.WriteString(w, completionJSON("It is sunny in Paris."))
+	})
+	c.SetTools([]Tool{NewFunctionTool("get_weather", "Get the weather of a city.", nil)})
+	c.AddMessageAsUser("What is the weather in Paris?")
+	handlers := map[string]func(args json.RawMessage) (string, error){
+		"get_weather": func(json.RawMessage) (string, error) { return "sunny", nil },
+	}
+
+	for {
+		res, err := c.NewChat()
+		if err != nil {
+			t.Fatalf("NewChat: %v", err)
+		}
+		if res.Choices[0].FinishReason != "tool_calls" {
+			break
+		}
+		if err := c.HandleToolCalls(res, handlers); err != nil {
+			t.Fatalf("HandleToolCalls: %v", err)
+		}
+	}
+
+	if reply, _ := c.LastAssistantMessage(); reply.Content != "It is sunny in Paris." || requests != 2 {
+		t.Errorf("reply = %q after %d requests, want the final answer after 2", reply.Content, requests)
+	}
+}